@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"messenger-auth/internal/config"
+	"messenger-auth/internal/jwt"
 	"messenger-auth/internal/server"
 	"messenger-auth/internal/storage/postgres"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	cfg := config.Load("config/config.yaml")
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	storage, err := postgres.NewStorage(cfg.DatabaseUrl, logger.With("component", "storage"))
@@ -19,10 +27,29 @@ func main() {
 	logger.Debug("storage connected")
 
 	defer storage.Close()
+
+	keys, err := jwt.LoadKeySet(cfg.KeysDir, cfg.KeyRetention, cfg.KeyPublishDelay, logger.With("component", "keys"))
+	if err != nil {
+		panic("failed to load signing keys")
+	}
+	logger.Debug("signing keys loaded", "kid", keys.SigningKey().ID)
+	go keys.RunRotation(ctx, cfg.KeyRotationInterval)
+
 	logger.Debug("starting auth service")
-	srv := server.NewServer(cfg, logger.With("component", "server"), storage)
-	logger.Info("auth service started", "address", fmt.Sprintf("%s:%v", cfg.Hostname, cfg.Port))
-	if err := srv.ServeHTTP(); err != nil {
-		logger.Error("failed to start server", "error", err)
+	srv := server.NewServer(cfg, logger.With("component", "server"), storage, keys)
+	go func() {
+		logger.Info("auth service started", "address", fmt.Sprintf("%s:%v", cfg.Hostname, cfg.Port))
+		if err := srv.ServeHTTP(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("failed to start server", "error", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to shut down server", "error", err)
 	}
 }
@@ -1,19 +1,30 @@
 package config
 
 import (
-	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	Hostname       string `yaml:"hostname" required:"true" env-default:"localhost"`
-	Port           uint16 `yaml:"port" default:"52525"`
-	DatabaseUrl    string `yaml:"database_url" env:"DATABASE_URL" env-required:"true"`
-	PrivateKeyPath string `yaml:"private_key_path" env:"PRIVATE_KEY_PATH" required:"true"`
-	PublicKeyPath  string `yaml:"public_key_path" env:"PUBLIC_KEY_PATH" required:"true"`
+	Hostname    string `yaml:"hostname" required:"true" env-default:"localhost"`
+	Port        uint16 `yaml:"port" default:"52525"`
+	DatabaseUrl string `yaml:"database_url" env:"DATABASE_URL" env-required:"true"`
+	// KeysDir holds the RSA signing keys, a key is generated on start when it is empty.
+	KeysDir             string        `yaml:"keys_dir" env:"KEYS_DIR" env-default:"keys"`
+	KeyRotationInterval time.Duration `yaml:"key_rotation_interval" env:"KEY_ROTATION_INTERVAL" env-default:"168h"`
+	// KeyRetention is how long a replaced key is still published, it must outlive the access tokens it signed.
+	KeyRetention time.Duration `yaml:"key_retention" env:"KEY_RETENTION" env-default:"1h"`
+	// KeyPublishDelay is how long a new key is published before it signs, it must be longer
+	// than the jwks refresh interval of the services verifying tokens.
+	KeyPublishDelay time.Duration `yaml:"key_publish_delay" env:"KEY_PUBLISH_DELAY" env-default:"10m"`
+	// AccessTokenTTL should stay short, revoked refresh tokens don't invalidate issued access tokens.
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" env:"ACCESS_TOKEN_TTL" env-default:"15m"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" env:"REFRESH_TOKEN_TTL" env-default:"720h"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish on SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" env-default:"20s"`
 }
 
 func Load(configPath string) *Config {
@@ -27,42 +38,11 @@ func Load(configPath string) *Config {
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
 		log.Fatalf("failed to read config: %v", err)
 	}
-
-	entries, err := os.ReadDir("/app/auth_service")
-	if err != nil {
-		log.Fatalf("ошибка чтения директории: %v", err)
-	}
-
-	for _, e := range entries {
-		info, err := e.Info()
-		if err != nil {
-			fmt.Printf("%s (ошибка: %v)\n", e.Name(), err)
-			continue
-		}
-
-		mode := info.Mode()
-		var kind string
-		switch {
-		case mode.IsDir():
-			kind = "DIR"
-		case mode.IsRegular():
-			kind = "FILE"
-		default:
-			kind = "OTHER"
-		}
-
-		fmt.Printf("%s\t%s\t%d bytes\n", kind, e.Name(), info.Size())
-	}
-
-	privatekey, err := os.ReadFile(cfg.PrivateKeyPath)
-	if err != nil {
-		log.Fatalf("failed to read private key: %v", err)
+	if cfg.KeyRetention < cfg.AccessTokenTTL {
+		log.Fatalf("key retention %v is shorter than access token ttl %v", cfg.KeyRetention, cfg.AccessTokenTTL)
 	}
-	publicKey, err := os.ReadFile(cfg.PublicKeyPath)
-	if err != nil {
-		log.Fatalf("failed to read public key: %v", err)
+	if cfg.KeyPublishDelay >= cfg.KeyRotationInterval {
+		log.Fatalf("key publish delay %v is not shorter than key rotation interval %v", cfg.KeyPublishDelay, cfg.KeyRotationInterval)
 	}
-	os.Setenv("AUTH_SERVICE_PRIVATE_KEY", string(privatekey))
-	os.Setenv("AUTH_SERVICE_PUBLIC_KEY", string(publicKey))
 	return &cfg
 }
@@ -1,29 +1,20 @@
 package jwt
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func CreateToken(id uint64) (string, error) {
-	claims := jwt.RegisteredClaims{Subject: fmt.Sprintf("%v", id), Issuer: "auth_service", ExpiresAt: &jwt.NumericDate{Time: time.Now().Add(time.Hour * 12)}}
+// CreateToken returns an access token of the user valid for ttl, signed with the
+// current signing key of the set.
+func CreateToken(keys *KeySet, id uint64, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{Subject: fmt.Sprintf("%v", id), Issuer: "auth_service", IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(ttl))}
 	tokenUnsigned := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
 
-	block, _ := pem.Decode([]byte(os.Getenv("AUTH_SERVICE_PRIVATE_KEY")))
-	if block == nil {
-		return "", fmt.Errorf("failed to parse PEM block containing the private key")
-	}
-
-	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-	if err != nil {
-		return "", err
-	}
-
-	return tokenUnsigned.SignedString(key.(*rsa.PrivateKey))
+	key := keys.SigningKey()
+	tokenUnsigned.Header["kid"] = key.ID
+	return tokenUnsigned.SignedString(key.Private)
 }
@@ -0,0 +1,297 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keySize = 2048
+
+// createdAtHeader is the PEM header holding the creation time of a key, file times
+// change when the directory is copied or restored.
+const createdAtHeader = "Created-At"
+
+type Key struct {
+	ID        string
+	Private   *rsa.PrivateKey
+	CreatedAt time.Time
+}
+
+// KeySet holds the RSA keys stored as <kid>.pem files in a directory. A new key is
+// published for publishDelay before it signs, so verifiers caching the keys know it
+// before the first token signed with it. The newest key past that delay signs new
+// tokens, the older ones stay published for verification until retention has passed
+// since they were replaced, so tokens signed before a rotation stay valid.
+type KeySet struct {
+	dir          string
+	retention    time.Duration
+	publishDelay time.Duration
+	logger       *slog.Logger
+	mu           sync.RWMutex
+	keys         []*Key // ordered by CreatedAt
+}
+
+func LoadKeySet(dir string, retention time.Duration, publishDelay time.Duration, logger *slog.Logger) (*KeySet, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	ks := &KeySet{dir: dir, retention: retention, publishDelay: publishDelay, logger: logger}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	if len(ks.keys) == 0 {
+		if err := ks.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// Reload reads the keys from the directory, keys written by other replicas sharing
+// the directory are picked up this way. Files that can't be read are logged and
+// skipped, one broken file doesn't take the other keys down. Retired keys are pruned.
+func (ks *KeySet) Reload() error {
+	paths, err := filepath.Glob(filepath.Join(ks.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	keys := make([]*Key, 0, len(paths))
+	for _, path := range paths {
+		key, err := readKey(path)
+		if err != nil {
+			ks.logger.Error("failed to read key, skipping it", "path", path, "error", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b *Key) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if len(keys) == 0 && len(ks.keys) > 0 {
+		// signing needs a key, rather keep the ones read before
+		ks.logger.Error("no readable keys left, keeping the loaded ones", "dir", ks.dir)
+		return nil
+	}
+	ks.keys = keys
+	ks.prune()
+	return nil
+}
+
+// Rotate generates the next signing key, it signs once publishDelay has passed. Keys
+// replaced by a signing key more than retention ago are retired.
+func (ks *KeySet) Rotate() error {
+	private, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return err
+	}
+	key := &Key{ID: thumbprint(&private.PublicKey), Private: private, CreatedAt: time.Now()}
+	if err := writeKey(ks.dir, key); err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = append(ks.keys, key)
+	ks.prune()
+	return nil
+}
+
+// prune retires the keys replaced by a signing key more than retention ago, ks.mu
+// must be held.
+func (ks *KeySet) prune() {
+	kept := ks.keys[:0]
+	for i, k := range ks.keys {
+		if i+1 < len(ks.keys) && time.Since(ks.keys[i+1].CreatedAt) > ks.publishDelay+ks.retention {
+			if err := os.Remove(filepath.Join(ks.dir, k.ID+".pem")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				ks.logger.Error("failed to remove retired key", "kid", k.ID, "error", err)
+			}
+			ks.logger.Info("key retired", "kid", k.ID)
+			continue
+		}
+		kept = append(kept, k)
+	}
+	ks.keys = kept
+}
+
+// RunRotation rotates the signing key every interval until ctx is done. The keys are
+// reloaded and pruned on every tick, retired keys leave the JWKS within a minute.
+func (ks *KeySet) RunRotation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(min(interval, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ks.Reload(); err != nil {
+				ks.logger.Error("failed to reload keys", "error", err)
+				continue
+			}
+			if newest := ks.newestKey(); newest != nil && time.Since(newest.CreatedAt) < interval {
+				continue
+			}
+			if err := ks.Rotate(); err != nil {
+				ks.logger.Error("failed to rotate key", "error", err)
+				continue
+			}
+			ks.logger.Info("next signing key published", "kid", ks.newestKey().ID, "signs_in", ks.publishDelay)
+		}
+	}
+}
+
+// SigningKey is the newest key published for publishDelay, or the oldest key when
+// none is, like the first key generated on an empty directory.
+func (ks *KeySet) SigningKey() *Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		if time.Since(ks.keys[i].CreatedAt) >= ks.publishDelay {
+			return ks.keys[i]
+		}
+	}
+	return ks.keys[0]
+}
+
+// newestKey is the last key generated, nil when the directory lost all of them.
+func (ks *KeySet) newestKey() *Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if len(ks.keys) == 0 {
+		return nil
+	}
+	return ks.keys[len(ks.keys)-1]
+}
+
+func (ks *KeySet) PublicKey(kid string) (*rsa.PublicKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, k := range ks.keys {
+		if k.ID == kid {
+			return &k.Private.PublicKey, true
+		}
+	}
+	return nil, false
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public part of every key that can still verify tokens.
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, k := range ks.keys {
+		n, e := encodePublicKey(&k.Private.PublicKey)
+		jwks.Keys = append(jwks.Keys, JWK{Kty: "RSA", Use: "sig", Alg: "RS256", Kid: k.ID, N: n, E: e})
+	}
+	return jwks
+}
+
+func writeKey(dir string, key *Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{createdAtHeader: key.CreatedAt.UTC().Format(time.RFC3339Nano)},
+		Bytes:   der,
+	})
+	return os.WriteFile(filepath.Join(dir, key.ID+".pem"), data, 0o600)
+}
+
+func readKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse PEM block containing the private key")
+	}
+	createdAt, err := keyCreatedAt(path, block)
+	if err != nil {
+		return nil, err
+	}
+	var private *rsa.PrivateKey
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		var key any
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if private, ok = key.(*rsa.PrivateKey); !ok {
+				err = fmt.Errorf("not an RSA private key")
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ID: strings.TrimSuffix(filepath.Base(path), ".pem"), Private: private, CreatedAt: createdAt}, nil
+}
+
+// keyCreatedAt reads the creation time from the PEM header, keys written before it
+// was stored fall back to the modification time of their file.
+func keyCreatedAt(path string, block *pem.Block) (time.Time, error) {
+	if value, ok := block.Headers[createdAtHeader]; ok {
+		createdAt, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s header: %w", createdAtHeader, err)
+		}
+		return createdAt, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func encodePublicKey(key *rsa.PublicKey) (string, string) {
+	n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	return n, e
+}
+
+// thumbprint is the RFC 7638 JWK thumbprint of the key, used as its kid.
+func thumbprint(key *rsa.PublicKey) string {
+	n, e := encodePublicKey(key)
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{E: e, Kty: "RSA", N: n})
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,63 @@
+package jwt
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestKeySet(t *testing.T, retention time.Duration) *KeySet {
+	t.Helper()
+	ks, err := LoadKeySet(t.TempDir(), retention, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func TestReloadSkipsBrokenKeys(t *testing.T) {
+	ks := newTestKeySet(t, time.Hour)
+	kid := ks.SigningKey().ID
+	if err := os.WriteFile(filepath.Join(ks.dir, "broken.pem"), []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Reload(); err != nil {
+		t.Fatalf("reload failed on a broken file: %v", err)
+	}
+	if len(ks.JWKS().Keys) != 1 || ks.SigningKey().ID != kid {
+		t.Errorf("got keys %+v, want only %s", ks.JWKS().Keys, kid)
+	}
+}
+
+func TestReloadPrunesRetiredKeys(t *testing.T) {
+	ks := newTestKeySet(t, time.Hour)
+	if err := ks.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	retired, signing := ks.keys[0], ks.keys[1]
+	// the key replacing it signs since longer than the retention
+	signing.CreatedAt = time.Now().Add(-2 * time.Hour)
+	if err := writeKey(ks.dir, signing); err != nil {
+		t.Fatal(err)
+	}
+	retired.CreatedAt = time.Now().Add(-3 * time.Hour)
+	if err := writeKey(ks.dir, retired); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ks.PublicKey(retired.ID); ok {
+		t.Error("retired key still published")
+	}
+	if _, err := os.Stat(filepath.Join(ks.dir, retired.ID+".pem")); !os.IsNotExist(err) {
+		t.Errorf("retired key file kept: %v", err)
+	}
+	if ks.SigningKey().ID != signing.ID {
+		t.Errorf("signing with %s, want %s", ks.SigningKey().ID, signing.ID)
+	}
+}
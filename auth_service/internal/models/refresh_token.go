@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// RefreshToken is stored only as a hash. Tokens rotated from the same login share
+// a FamilyId, so the whole chain can be revoked at once.
+type RefreshToken struct {
+	Id         uint64
+	UserId     uint64
+	FamilyId   string
+	TokenHash  string
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+	ReplacedBy *uint64
+	Created_at time.Time
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"messenger-auth/internal/jwt"
+	"net/http"
+)
+
+// JWKS publishes the public keys other services verify access tokens with.
+func JWKS(logger *slog.Logger, keys *jwt.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if err := json.NewEncoder(w).Encode(keys.JWKS()); err != nil {
+			logger.Error("failed to write jwks", "error", err)
+		}
+	}
+}
@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"log/slog"
+	"messenger-auth/internal/config"
 	"messenger-auth/internal/jwt"
 	"messenger-auth/internal/models"
 	"messenger-auth/internal/storage"
@@ -17,7 +17,7 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
-func Login(logger *slog.Logger, storage storage.Storage) http.HandlerFunc {
+func Login(logger *slog.Logger, storage storage.Storage, cfg *config.Config, keys *jwt.KeySet) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("login request received")
@@ -50,17 +50,21 @@ func Login(logger *slog.Logger, storage storage.Storage) http.HandlerFunc {
 			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 			return
 		}
-		// create token
-		token, err := jwt.CreateToken(u.Id)
+		// create tokens
+		tokens, _, err := issueTokens(uow, cfg, keys, u.Id, "")
 		if err != nil {
-			logger.Error("failed to create token", "error", err)
+			logger.Error("failed to create tokens", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		if _, err = w.Write([]byte(fmt.Sprintf(`{"token": "%s", "id": %v}`, token, u.Id))); err != nil {
-			logger.Error("failed to write token to response", "error", err)
+		if err = uow.Commit(); err != nil {
+			logger.Error("failed to commit login", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err = writeTokens(w, http.StatusOK, tokens); err != nil {
+			logger.Error("failed to write token to response", "error", err)
+			return
 		}
 		logger.Info("user logged in successfully", "user_id", u.Id)
 	}
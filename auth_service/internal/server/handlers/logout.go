@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"messenger-auth/internal/jwt"
+	"messenger-auth/internal/storage"
+	"net/http"
+)
+
+// Logout revokes the refresh token and every token rotated from the same login.
+func Logout(logger *slog.Logger, storage storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("logout request received")
+		var req RefreshTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			logger.Error("failed to decode logout request", "error", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		uow, err := storage.CreateUnitOfWork()
+		if err != nil {
+			logger.Error("failed to create unit of work", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer uow.Rollback()
+		repo := uow.RefreshTokenRepository()
+		stored, err := repo.GetByHash(jwt.HashRefreshToken(req.RefreshToken))
+		if err != nil {
+			if isNotFound(err) {
+				logger.Error("unknown refresh token")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			logger.Error("failed to get refresh token", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err = repo.RevokeFamily(stored.FamilyId); err != nil {
+			logger.Error("failed to revoke refresh token family", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err = uow.Commit(); err != nil {
+			logger.Error("failed to commit logout", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		logger.Info("user logged out", "user_id", stored.UserId)
+	}
+}
+
+// LogoutAll revokes every refresh token of the user owning the access token, logging
+// out all devices once their access tokens expire.
+func LogoutAll(logger *slog.Logger, storage storage.Storage, keys *jwt.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Debug("logout all request received")
+		token := r.Header.Get("Authorization")
+		if len(token) < len("Bearer ") {
+			logger.Error("no authorization header")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		token = token[len("Bearer "):]
+		id, err := jwt.ParseToken(keys, token)
+		if err != nil {
+			logger.Error("failed to parse token", "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		uow, err := storage.CreateUnitOfWork()
+		if err != nil {
+			logger.Error("failed to create unit of work", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer uow.Rollback()
+		if err = uow.RefreshTokenRepository().RevokeAllForUser(id); err != nil {
+			logger.Error("failed to revoke refresh tokens", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err = uow.Commit(); err != nil {
+			logger.Error("failed to commit logout", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		logger.Info("user logged out from all devices", "user_id", id)
+	}
+}
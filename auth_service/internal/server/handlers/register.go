@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"log/slog"
+	"messenger-auth/internal/config"
 	"messenger-auth/internal/jwt"
 	"messenger-auth/internal/models"
 	"messenger-auth/internal/storage"
@@ -17,7 +17,7 @@ type RegisterRequest struct {
 	Password string `json:"password"`
 }
 
-func Register(logger *slog.Logger, storage storage.Storage) http.HandlerFunc {
+func Register(logger *slog.Logger, storage storage.Storage, cfg *config.Config, keys *jwt.KeySet) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("register request received")
 		var req RegisterRequest
@@ -50,16 +50,10 @@ func Register(logger *slog.Logger, storage storage.Storage) http.HandlerFunc {
 			http.Error(w, "User already exists", http.StatusUnauthorized)
 			return
 		}
-		// create token
-		token, err := jwt.CreateToken(u.Id)
+		// create tokens
+		tokens, _, err := issueTokens(uow, cfg, keys, u.Id, "")
 		if err != nil {
-			logger.Error("failed to create token", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusCreated)
-		if _, err = w.Write([]byte(fmt.Sprintf(`{"token": "%s", "id": %v}`, token, u.Id))); err != nil {
-			logger.Error("failed to write token to response", "error", err)
+			logger.Error("failed to create tokens", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -68,6 +62,10 @@ func Register(logger *slog.Logger, storage storage.Storage) http.HandlerFunc {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		if err = writeTokens(w, http.StatusCreated, tokens); err != nil {
+			logger.Error("failed to write token to response", "error", err)
+			return
+		}
 		logger.Info("user registered successfully", "user_id", u.Id)
 	}
 }
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"messenger-auth/internal/config"
+	"messenger-auth/internal/jwt"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/storage"
+	"net/http"
+	"time"
+)
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	Id           uint64 `json:"id"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// issueTokens creates an access token and stores a new refresh token in the given family,
+// an empty familyId starts a new one. The unit of work still has to be committed.
+func issueTokens(uow storage.UnitOfWork, cfg *config.Config, keys *jwt.KeySet, userId uint64, familyId string) (*TokenResponse, *models.RefreshToken, error) {
+	token, err := jwt.CreateToken(keys, userId, cfg.AccessTokenTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshToken, err := jwt.CreateRefreshToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	stored := &models.RefreshToken{
+		UserId:    userId,
+		FamilyId:  familyId,
+		TokenHash: jwt.HashRefreshToken(refreshToken),
+		ExpiresAt: time.Now().Add(cfg.RefreshTokenTTL),
+	}
+	if err := uow.RefreshTokenRepository().Create(stored); err != nil {
+		return nil, nil, err
+	}
+	return &TokenResponse{Token: token, RefreshToken: refreshToken, Id: userId}, stored, nil
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, storage.ErrNotFound)
+}
+
+func writeTokens(w http.ResponseWriter, status int, tokens *TokenResponse) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(tokens)
+}
@@ -1,35 +1,87 @@
 package handlers
 
 import (
+	"encoding/json"
 	"log/slog"
+	"messenger-auth/internal/config"
 	"messenger-auth/internal/jwt"
+	"messenger-auth/internal/storage"
 	"net/http"
+	"time"
 )
 
-func UpdateToken(logger *slog.Logger) http.HandlerFunc {
+// UpdateToken exchanges a refresh token for a new access token and a new refresh token.
+// A refresh token can be used only once: presenting an already rotated token means it
+// leaked, so its whole family is revoked and the user has to log in again.
+func UpdateToken(logger *slog.Logger, storage storage.Storage, cfg *config.Config, keys *jwt.KeySet) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("update token request received")
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			logger.Error("no authorization header")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		var req RefreshTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			logger.Error("failed to decode update token request", "error", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		uow, err := storage.CreateUnitOfWork()
+		if err != nil {
+			logger.Error("failed to create unit of work", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		token = token[len("Bearer "):]
-		id, err := jwt.ParseToken(token)
+		defer uow.Rollback()
+		repo := uow.RefreshTokenRepository()
+		stored, err := repo.GetByHash(jwt.HashRefreshToken(req.RefreshToken))
 		if err != nil {
-			logger.Error("failed to parse token", "error", err)
+			if isNotFound(err) {
+				logger.Error("unknown refresh token")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			logger.Error("failed to get refresh token", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if stored.RevokedAt != nil {
+			logger.Warn("refresh token reuse detected, revoking family", "user_id", stored.UserId, "family_id", stored.FamilyId)
+			if err = repo.RevokeFamily(stored.FamilyId); err != nil {
+				logger.Error("failed to revoke refresh token family", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if err = uow.Commit(); err != nil {
+				logger.Error("failed to commit family revocation", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		newToken, err := jwt.CreateToken(id)
+		if time.Now().After(stored.ExpiresAt) {
+			logger.Error("refresh token expired", "user_id", stored.UserId)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		tokens, next, err := issueTokens(uow, cfg, keys, stored.UserId, stored.FamilyId)
 		if err != nil {
-			logger.Error("failed to create new token", "error", err)
+			logger.Error("failed to create tokens", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		w.Header().Set("Authorization", "Bearer "+newToken)
-		w.WriteHeader(http.StatusCreated)
-		logger.Info("token created", "user_id", id)
+		if err = repo.Replace(stored.Id, next.Id); err != nil {
+			logger.Error("failed to rotate refresh token", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err = uow.Commit(); err != nil {
+			logger.Error("failed to commit token rotation", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Authorization", "Bearer "+tokens.Token)
+		if err = writeTokens(w, http.StatusCreated, tokens); err != nil {
+			logger.Error("failed to write token to response", "error", err)
+			return
+		}
+		logger.Info("token created", "user_id", stored.UserId)
 	}
 }
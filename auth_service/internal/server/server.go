@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"messenger-auth/internal/config"
+	"messenger-auth/internal/jwt"
 	"messenger-auth/internal/server/handlers"
 	"messenger-auth/internal/storage"
 	"net/http"
@@ -15,17 +17,36 @@ type Server struct {
 	config  *config.Config
 	logger  *slog.Logger
 	storage storage.Storage
+	keys    *jwt.KeySet
 	router  *mux.Router
+	http    *http.Server
 }
 
-func NewServer(config *config.Config, logger *slog.Logger, storage storage.Storage) *Server {
-	return &Server{config: config, logger: logger, storage: storage, router: mux.NewRouter()}
+func NewServer(config *config.Config, logger *slog.Logger, storage storage.Storage, keys *jwt.KeySet) *Server {
+	router := mux.NewRouter()
+	return &Server{
+		config:  config,
+		logger:  logger,
+		storage: storage,
+		keys:    keys,
+		router:  router,
+		http:    &http.Server{Addr: fmt.Sprintf("%s:%v", config.Hostname, config.Port), Handler: router},
+	}
 }
 
 func (s *Server) ServeHTTP() error {
-	s.router.Handle("/update_token", handlers.UpdateToken(s.logger.With("handler", "update_token"))).Methods("POST")
-	s.router.Handle("/register", handlers.Register(s.logger.With("handler", "register"), s.storage)).Methods("POST")
-	s.router.Handle("/login", handlers.Login(s.logger.With("handler", "login"), s.storage)).Methods("POST")
+	s.router.Handle("/update_token", handlers.UpdateToken(s.logger.With("handler", "update_token"), s.storage, s.config, s.keys)).Methods("POST")
+	s.router.Handle("/register", handlers.Register(s.logger.With("handler", "register"), s.storage, s.config, s.keys)).Methods("POST")
+	s.router.Handle("/login", handlers.Login(s.logger.With("handler", "login"), s.storage, s.config, s.keys)).Methods("POST")
+	s.router.Handle("/logout", handlers.Logout(s.logger.With("handler", "logout"), s.storage)).Methods("POST")
+	s.router.Handle("/logout_all", handlers.LogoutAll(s.logger.With("handler", "logout_all"), s.storage, s.keys)).Methods("POST")
+	s.router.Handle("/.well-known/jwks.json", handlers.JWKS(s.logger.With("handler", "jwks"), s.keys)).Methods("GET")
 
-	return http.ListenAndServe(fmt.Sprintf("%s:%v", s.config.Hostname, s.config.Port), s.router)
+	return s.http.ListenAndServe()
+}
+
+// Shutdown stops accepting connections and waits for the requests in flight, and the
+// transactions they run, to finish until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
 }
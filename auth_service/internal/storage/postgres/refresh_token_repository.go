@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type RefreshTokenRepository struct {
+	tx     pgx.Tx
+	logger *slog.Logger
+}
+
+func (r *RefreshTokenRepository) Create(token *models.RefreshToken) error {
+	err := r.tx.QueryRow(context.Background(), "INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, COALESCE(NULLIF($2, '')::uuid, gen_random_uuid()), $3, $4) RETURNING id, family_id::text, created_at", token.UserId, token.FamilyId, token.TokenHash, token.ExpiresAt).Scan(&token.Id, &token.FamilyId, &token.Created_at)
+	if err != nil {
+		r.logger.Error("failed save refresh token", "error", err)
+	}
+	return err
+}
+
+func (r *RefreshTokenRepository) GetByHash(hash string) (*models.RefreshToken, error) {
+	token := &models.RefreshToken{TokenHash: hash}
+	err := r.tx.QueryRow(context.Background(), "SELECT id, user_id, family_id::text, expires_at, revoked_at, replaced_by, created_at FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE", hash).Scan(&token.Id, &token.UserId, &token.FamilyId, &token.ExpiresAt, &token.RevokedAt, &token.ReplacedBy, &token.Created_at)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %w", storage.ErrNotFound, err)
+	}
+	if err != nil {
+		r.logger.Error("failed get refresh token", "error", err)
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *RefreshTokenRepository) Replace(id uint64, replacedBy uint64) error {
+	_, err := r.tx.Exec(context.Background(), "UPDATE refresh_tokens SET revoked_at = now(), replaced_by = $1 WHERE id = $2", replacedBy, id)
+	if err != nil {
+		r.logger.Error("failed replace refresh token", "error", err)
+	}
+	return err
+}
+
+func (r *RefreshTokenRepository) RevokeFamily(familyId string) error {
+	_, err := r.tx.Exec(context.Background(), "UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL", familyId)
+	if err != nil {
+		r.logger.Error("failed revoke refresh token family", "error", err)
+	}
+	return err
+}
+
+func (r *RefreshTokenRepository) RevokeAllForUser(userId uint64) error {
+	_, err := r.tx.Exec(context.Background(), "UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userId)
+	if err != nil {
+		r.logger.Error("failed revoke user refresh tokens", "error", err)
+	}
+	return err
+}
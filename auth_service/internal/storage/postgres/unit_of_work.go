@@ -9,19 +9,24 @@ import (
 )
 
 type UnitOfWork struct {
-	tx       pgx.Tx
-	userRepo UserRepository
-	logger   *slog.Logger
+	tx               pgx.Tx
+	userRepo         UserRepository
+	refreshTokenRepo RefreshTokenRepository
+	logger           *slog.Logger
 }
 
 func NewUnitOfWork(tx pgx.Tx, logger *slog.Logger) *UnitOfWork {
-	return &UnitOfWork{tx: tx, userRepo: UserRepository{tx: tx, logger: logger}, logger: logger}
+	return &UnitOfWork{tx: tx, userRepo: UserRepository{tx: tx, logger: logger}, refreshTokenRepo: RefreshTokenRepository{tx: tx, logger: logger}, logger: logger}
 }
 
 func (u *UnitOfWork) UserRepository() storage.UserRepository {
 	return &u.userRepo
 }
 
+func (u *UnitOfWork) RefreshTokenRepository() storage.RefreshTokenRepository {
+	return &u.refreshTokenRepo
+}
+
 func (u *UnitOfWork) Commit() error {
 	return u.tx.Commit(context.Background())
 }
@@ -1,6 +1,11 @@
 package storage
 
-import "messenger-auth/internal/models"
+import (
+	"errors"
+	"messenger-auth/internal/models"
+)
+
+var ErrNotFound = errors.New("not found")
 
 type Storage interface {
 	CreateUnitOfWork() (UnitOfWork, error)
@@ -9,6 +14,7 @@ type Storage interface {
 
 type UnitOfWork interface {
 	UserRepository() UserRepository
+	RefreshTokenRepository() RefreshTokenRepository
 	Commit() error
 	Rollback() error
 }
@@ -17,3 +23,13 @@ type UserRepository interface {
 	Register(User *models.User) error
 	Login(User *models.User) error
 }
+
+type RefreshTokenRepository interface {
+	// Create stores the token, a new family is started when FamilyId is empty.
+	Create(token *models.RefreshToken) error
+	// GetByHash locks the token until the unit of work ends.
+	GetByHash(hash string) (*models.RefreshToken, error)
+	Replace(id uint64, replacedBy uint64) error
+	RevokeFamily(familyId string) error
+	RevokeAllForUser(userId uint64) error
+}
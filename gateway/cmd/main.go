@@ -1,98 +1,57 @@
 package main
 
 import (
-	"net"
+	"context"
+	"errors"
+	"log/slog"
+	"messenger-gateway/internal/config"
+	"messenger-gateway/internal/server"
+	"messenger-shared/jwt"
+	"messenger-shared/ratelimit"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
-	"time"
-
-	"github.com/gorilla/websocket"
-)
-
-const (
-	authHttpBackendURL = "http://auth:52521"
-	chatWSBackendURL   = "ws://websocket:52522/ws"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
-func newHTTPReverseProxy(target string) *httputil.ReverseProxy {
-	url, err := url.Parse(target)
-	if err != nil {
-		panic(err)
-	}
-	rp := httputil.NewSingleHostReverseProxy(url)
-	origDirector := rp.Director
-	rp.Director = func(r *http.Request) {
-		origDirector(r)
-		if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-			r.Header.Set("X-Real-IP", clientIP)
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg := config.Load("config/config.yaml")
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	keys := jwt.NewJWKSCache(cfg.JWKSUrl, cfg.JWKSRefreshInterval)
+	if err := keys.Refresh(ctx); err != nil {
+		// auth_service may still be starting, keys are fetched again on the first request
+		logger.Warn("failed to fetch jwks", "error", err)
+	}
+
+	var limits ratelimit.Store = ratelimit.NewMemory()
+	if cfg.RateLimit.Store == "postgres" {
+		pg, err := ratelimit.NewPostgres(cfg.DatabaseUrl, logger.With("component", "ratelimit"))
+		if err != nil {
+			panic("failed to init rate limit store")
 		}
-		r.Header.Set("X-Forwarded-Proto", "http")
+		defer pg.Close()
+		limits = pg
 	}
 
-	rp.Transport = &http.Transport{
-		Proxy:           http.ProxyFromEnvironment,
-		DialContext:     (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
-		IdleConnTimeout: 30 * time.Second,
-	}
-
-	return rp
-}
-
-func HandleWebSocketProxy(w http.ResponseWriter, r *http.Request) {
-	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
-	clientConn, err := upgrader.Upgrade(w, r, nil)
+	srv, err := server.NewServer(cfg, keys, limits, logger.With("component", "server"))
 	if err != nil {
-		panic(err)
-	}
-
-	backendUrl, _ := url.Parse(chatWSBackendURL)
-	dialer := websocket.Dialer{
-		Proxy:            http.ProxyFromEnvironment,
-		HandshakeTimeout: 30 * time.Second,
+		panic("failed to init server: " + err.Error())
 	}
-	requestHeader := http.Header{}
-	if token := r.Header.Get("Authorization"); token != "" {
-		requestHeader.Set("Authorization", token)
-	}
-
-	backendConn, _, err := dialer.Dial(backendUrl.String(), requestHeader)
-	if err != nil {
-		clientConn.Close()
-	}
-
-	proxy := func(src, dst *websocket.Conn) {
-		defer src.Close()
-		defer dst.Close()
-		for {
-			mt, message, err := src.ReadMessage()
-			if err != nil {
-				return
-			}
-			err = dst.WriteMessage(mt, message)
-			if err != nil {
-				return
-			}
+	go func() {
+		logger.Info("starting gateway", "host", cfg.Hostname, "port", cfg.Port)
+		if err := srv.ServeHttp(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("failed to start server", "error", err)
+			stop()
 		}
-	}
+	}()
 
-	go proxy(clientConn, backendConn)
-	go proxy(backendConn, clientConn)
-}
-
-func main() {
-	httpProxy := newHTTPReverseProxy(authHttpBackendURL)
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/ws", HandleWebSocketProxy)
-	mux.Handle("/", httpProxy)
-	server := &http.Server{
-		Addr:         ":8081",
-		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to shut down server", "error", err)
 	}
-
-	server.ListenAndServe()
 }
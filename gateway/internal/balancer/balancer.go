@@ -0,0 +1,139 @@
+package balancer
+
+import (
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"messenger-gateway/internal/config"
+)
+
+var ErrNoUpstream = errors.New("no upstream available")
+
+// Upstream is one instance of a service. It is available while its health probe
+// passes and it isn't ejected after failed requests.
+type Upstream struct {
+	URL    *url.URL
+	active atomic.Int64
+
+	mu           sync.Mutex
+	healthy      bool
+	fails        int
+	ejectedUntil time.Time
+}
+
+func (u *Upstream) available(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.healthy && !now.Before(u.ejectedUntil)
+}
+
+// Active is the number of requests and websocket relays the upstream is serving.
+func (u *Upstream) Active() int64 {
+	return u.active.Load()
+}
+
+// Pool balances the requests of a route between its upstreams.
+type Pool struct {
+	route     config.Route
+	upstreams []*Upstream
+	next      atomic.Uint64
+	logger    *slog.Logger
+}
+
+func NewPool(route config.Route, logger *slog.Logger) (*Pool, error) {
+	p := &Pool{route: route, logger: logger}
+	for _, raw := range route.Upstreams {
+		target, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		p.upstreams = append(p.upstreams, &Upstream{URL: target, healthy: true})
+	}
+	return p, nil
+}
+
+// Pick chooses an available upstream with the balance policy of the route and counts
+// the request on it, Release must be called when it is done.
+func (p *Pool) Pick() (*Upstream, error) {
+	candidates := p.candidates()
+	if len(candidates) == 0 {
+		return nil, ErrNoUpstream
+	}
+	start := int(p.next.Add(1) % uint64(len(candidates)))
+	picked := candidates[start]
+	if p.route.Balance == config.LeastConnections {
+		for i := 1; i < len(candidates); i++ {
+			u := candidates[(start+i)%len(candidates)]
+			if u.Active() < picked.Active() {
+				picked = u
+			}
+		}
+	}
+	picked.active.Add(1)
+	return picked, nil
+}
+
+// PickSticky sends the same key to the same upstream while it is available, upstreams
+// coming and going only move the keys of that upstream (rendezvous hashing). An empty
+// key falls back to Pick.
+func (p *Pool) PickSticky(key string) (*Upstream, error) {
+	if key == "" {
+		return p.Pick()
+	}
+	var picked *Upstream
+	var best uint64
+	for _, u := range p.candidates() {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte(u.URL.String()))
+		if score := h.Sum64(); picked == nil || score > best {
+			picked, best = u, score
+		}
+	}
+	if picked == nil {
+		return nil, ErrNoUpstream
+	}
+	picked.active.Add(1)
+	return picked, nil
+}
+
+func (p *Pool) Release(u *Upstream) {
+	u.active.Add(-1)
+}
+
+// Success resets the failures of the upstream.
+func (p *Pool) Success(u *Upstream) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails = 0
+}
+
+// Failure counts a failed request, MaxFails failures in a row eject the upstream for
+// FailTimeout.
+func (p *Pool) Failure(u *Upstream, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails++
+	if u.fails < p.route.MaxFails {
+		return
+	}
+	u.fails = 0
+	u.ejectedUntil = time.Now().Add(p.route.FailTimeout)
+	p.logger.Warn("upstream ejected", "upstream", u.URL.String(), "error", err, "for", p.route.FailTimeout)
+}
+
+func (p *Pool) candidates() []*Upstream {
+	now := time.Now()
+	candidates := make([]*Upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.available(now) {
+			candidates = append(candidates, u)
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,62 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// RunHealthChecks probes every upstream each interval until ctx is done, it returns
+// right away when the route has no health check path.
+func (p *Pool) RunHealthChecks(ctx context.Context) {
+	check := p.route.HealthCheck
+	if check.Path == "" {
+		return
+	}
+	client := &http.Client{Timeout: check.Timeout}
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+	for {
+		for _, u := range p.upstreams {
+			p.probe(ctx, client, u)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) probe(ctx context.Context, client *http.Client, u *Upstream) {
+	target := *u.URL
+	// websocket upstreams serve plain http next to the upgrade endpoint
+	switch target.Scheme {
+	case "ws":
+		target.Scheme = "http"
+	case "wss":
+		target.Scheme = "https"
+	}
+	target.Path, target.RawQuery = p.route.HealthCheck.Path, ""
+
+	healthy := false
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			healthy = resp.StatusCode < http.StatusInternalServerError
+		}
+	}
+
+	u.mu.Lock()
+	changed := u.healthy != healthy
+	u.healthy = healthy
+	u.mu.Unlock()
+	if changed && healthy {
+		p.logger.Info("upstream healthy", "upstream", u.URL.String())
+	} else if changed {
+		p.logger.Warn("upstream unhealthy", "upstream", u.URL.String(), "error", err)
+	}
+}
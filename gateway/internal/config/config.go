@@ -2,15 +2,96 @@ package config
 
 import (
 	"log"
+	"net/url"
 	"os"
+	"strings"
+	"time"
+
+	"messenger-shared/ratelimit"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
+const (
+	RouteHTTP      = "http"
+	RouteWebsocket = "websocket"
+
+	RoundRobin       = "round_robin"
+	LeastConnections = "least_connections"
+
+	defaultRouteTimeout        = 30 * time.Second
+	defaultMaxFails            = 3
+	defaultFailTimeout         = 30 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	defaultIdleTimeout         = 2 * time.Minute
+)
+
 type Config struct {
-	Hostname    string `yaml:"hostname" env:"HOSTNAME" env-required:"true" env-default:"localhost"`
-	Port        uint16 `yaml:"port" default:"8080"`
-	DatabaseUrl string `yaml:"database_url" env:"DATABASE_URL" env-required:"true"`
+	Hostname string `yaml:"hostname" env:"GATEWAY_HOSTNAME" env-default:"0.0.0.0"`
+	Port     uint16 `yaml:"port" env:"GATEWAY_PORT" env-default:"8081"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish on SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" env-default:"20s"`
+	// JWKSUrl is where auth_service publishes the keys tokens are verified with.
+	JWKSUrl             string        `yaml:"jwks_url" env:"JWKS_URL" env-default:"http://auth:52521/.well-known/jwks.json"`
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval" env:"JWKS_REFRESH_INTERVAL" env-default:"5m"`
+	// PublicPaths are forwarded without a token, every other request needs a valid
+	// access token. A path ending in / matches everything below it.
+	PublicPaths []string `yaml:"public_paths" env:"PUBLIC_PATHS" env-separator:"," env-default:"/login,/register,/update_token,/logout,/.well-known/jwks.json"`
+	// DatabaseUrl is only needed by the postgres rate limit store.
+	DatabaseUrl string    `yaml:"database_url" env:"DATABASE_URL"`
+	RateLimit   RateLimit `yaml:"rate_limit"`
+	// Routes is the routing table, a request goes to the route with the longest matching prefix.
+	Routes []Route `yaml:"routes"`
+}
+
+// RateLimit throttles requests before they are proxied, a limit without rate is off.
+type RateLimit struct {
+	// Store is memory, or postgres to share the buckets between gateway replicas.
+	Store   string          `yaml:"store" env:"RATE_LIMIT_STORE" env-default:"memory"`
+	PerIP   ratelimit.Limit `yaml:"per_ip"`
+	PerUser ratelimit.Limit `yaml:"per_user"`
+	// Paths are stricter per IP limits of single paths, like /login.
+	Paths []PathLimit `yaml:"paths"`
+}
+
+type PathLimit struct {
+	Path            string `yaml:"path"`
+	ratelimit.Limit `yaml:",inline"`
+}
+
+type Route struct {
+	Prefix string `yaml:"prefix"`
+	// Upstreams are the instances of the service, websocket routes dial the URL as is
+	// for every upgrade.
+	Upstreams []string `yaml:"upstreams"`
+	Type      string   `yaml:"type"`
+	// Balance is round_robin or least_connections. Websocket upgrades of a known user
+	// go to the same upstream as long as it is up, whatever the policy.
+	Balance string `yaml:"balance"`
+	// Timeout is how long the upstream gets to answer: response headers for http routes,
+	// the handshake for websocket routes.
+	Timeout     time.Duration `yaml:"timeout"`
+	HealthCheck HealthCheck   `yaml:"health_check"`
+	// MaxFails failed requests in a row eject an upstream for FailTimeout.
+	MaxFails    int           `yaml:"max_fails"`
+	FailTimeout time.Duration `yaml:"fail_timeout"`
+	// AllowedOrigins are the browser origins a websocket route accepts besides its own
+	// host, "*" accepts any. Clients without an Origin header are always accepted.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// IdleTimeout closes a websocket relay when neither side sent a frame, pings and
+	// pongs included, for that long.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// MaxConnections caps the open websocket relays of the route, 0 is no cap.
+	MaxConnections int `yaml:"max_connections"`
+}
+
+// HealthCheck probes every upstream of a route with a GET of Path, anything but a 5xx
+// answer in time is healthy. Probing is off without a path.
+type HealthCheck struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
 }
 
 func Load(configPath string) *Config {
@@ -24,5 +105,62 @@ func Load(configPath string) *Config {
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
 		log.Fatalf("failed to read config: %v", err)
 	}
+	if cfg.RateLimit.Store != "postgres" && cfg.RateLimit.Store != "memory" {
+		log.Fatalf("rate_limit.store must be one of postgres, memory: %q", cfg.RateLimit.Store)
+	}
+	if cfg.RateLimit.Store == "postgres" && cfg.DatabaseUrl == "" {
+		log.Fatalf("database_url is required by the postgres rate limit store")
+	}
+	if len(cfg.Routes) == 0 {
+		log.Fatalf("at least one route is required")
+	}
+	for i := range cfg.Routes {
+		route := &cfg.Routes[i]
+		if !strings.HasPrefix(route.Prefix, "/") {
+			log.Fatalf("route prefix must start with /: %q", route.Prefix)
+		}
+		if route.Type != RouteHTTP && route.Type != RouteWebsocket {
+			log.Fatalf("route %s: type must be one of http, websocket: %q", route.Prefix, route.Type)
+		}
+		if len(route.Upstreams) == 0 {
+			log.Fatalf("route %s needs at least one upstream", route.Prefix)
+		}
+		for _, raw := range route.Upstreams {
+			upstream, err := url.Parse(raw)
+			if err != nil || upstream.Host == "" {
+				log.Fatalf("route %s has an invalid upstream: %q", route.Prefix, raw)
+			}
+			if route.Type == RouteHTTP && upstream.Scheme != "http" && upstream.Scheme != "https" {
+				log.Fatalf("route %s: http upstream must be http or https: %q", route.Prefix, raw)
+			}
+			if route.Type == RouteWebsocket && upstream.Scheme != "ws" && upstream.Scheme != "wss" {
+				log.Fatalf("route %s: websocket upstream must be ws or wss: %q", route.Prefix, raw)
+			}
+		}
+		if route.Balance == "" {
+			route.Balance = RoundRobin
+		}
+		if route.Balance != RoundRobin && route.Balance != LeastConnections {
+			log.Fatalf("route %s: balance must be one of round_robin, least_connections: %q", route.Prefix, route.Balance)
+		}
+		if route.Timeout <= 0 {
+			route.Timeout = defaultRouteTimeout
+		}
+		if route.MaxFails <= 0 {
+			route.MaxFails = defaultMaxFails
+		}
+		if route.FailTimeout <= 0 {
+			route.FailTimeout = defaultFailTimeout
+		}
+		if route.IdleTimeout <= 0 {
+			route.IdleTimeout = defaultIdleTimeout
+		}
+		if route.HealthCheck.Interval <= 0 {
+			route.HealthCheck.Interval = defaultHealthCheckInterval
+		}
+		if route.HealthCheck.Timeout <= 0 {
+			route.HealthCheck.Timeout = defaultHealthCheckTimeout
+		}
+	}
 	return &cfg
 }
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"messenger-gateway/internal/balancer"
+	"messenger-gateway/internal/config"
+)
+
+type upstreamKey struct{}
+
+// HTTP forwards requests to an upstream of the route, the request path is kept as is.
+type HTTP struct {
+	pool   *balancer.Pool
+	rp     *httputil.ReverseProxy
+	logger *slog.Logger
+}
+
+func NewHTTP(route config.Route, pool *balancer.Pool, logger *slog.Logger) *HTTP {
+	p := &HTTP{pool: pool, logger: logger}
+	p.rp = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(upstreamOf(pr.In).URL)
+			pr.SetXForwarded()
+			pr.Out.Host = pr.In.Host
+			if clientIP, _, err := net.SplitHostPort(pr.In.RemoteAddr); err == nil {
+				pr.Out.Header.Set("X-Real-IP", clientIP)
+			}
+			pr.Out.Header.Set("X-Forwarded-Proto", "http")
+		},
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: route.Timeout}).DialContext,
+			ResponseHeaderTimeout: route.Timeout,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+	}
+	return p
+}
+
+func (p *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, err := p.pool.Pick()
+	if err != nil {
+		p.logger.Error("failed to pick upstream", "error", err, "path", r.URL.Path)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.pool.Release(u)
+	p.rp.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), upstreamKey{}, u)))
+}
+
+// modifyResponse counts the gateway errors of an upstream as failures, other answers
+// show it is up.
+func (p *HTTP) modifyResponse(resp *http.Response) error {
+	u := upstreamOf(resp.Request)
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		p.pool.Failure(u, errors.New(resp.Status))
+	default:
+		p.pool.Success(u)
+	}
+	return nil
+}
+
+func (p *HTTP) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	u := upstreamOf(r)
+	// a client going away says nothing about the upstream
+	if !errors.Is(err, context.Canceled) {
+		p.pool.Failure(u, err)
+	}
+	p.logger.Error("failed to proxy request", "error", err, "upstream", u.URL.String(), "path", r.URL.Path)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+func upstreamOf(r *http.Request) *balancer.Upstream {
+	return r.Context().Value(upstreamKey{}).(*balancer.Upstream)
+}
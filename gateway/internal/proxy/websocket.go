@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"messenger-gateway/internal/balancer"
+	"messenger-gateway/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait = 10 * time.Second
+	// upstreamLost tells the client the upstream went away without a close frame, so
+	// it reconnects. 1014 bad gateway would fit better but clients like gorilla refuse it.
+	upstreamLost = websocket.CloseServiceRestart
+)
+
+// UserIDHeader carries the user id the gateway verified to the backends, a value sent
+// by the client is always dropped.
+const UserIDHeader = "X-User-ID"
+
+// forwardedHeaders are copied from the client handshake to the upstream one.
+var forwardedHeaders = []string{"Authorization", UserIDHeader, "X-Forwarded-For", "User-Agent"}
+
+// WebSocket relays client connections to an upstream of the route, the sessions of a
+// user go to the same upstream. The upstream is dialed before the client is upgraded,
+// so a refused handshake reaches the client as a plain http answer. Frames, close
+// codes, pings and pongs are passed through as they come.
+type WebSocket struct {
+	route    config.Route
+	pool     *balancer.Pool
+	dialer   websocket.Dialer
+	upgrader websocket.Upgrader
+	logger   *slog.Logger
+
+	// conns holds the client side of every open relay, so shutdown can ask the
+	// clients to reconnect instead of dropping them. active also counts the relays
+	// still dialing, so the connection cap holds while handshakes are in flight.
+	mu     sync.Mutex
+	conns  map[*websocket.Conn]struct{}
+	active int
+}
+
+func NewWebSocket(route config.Route, pool *balancer.Pool, logger *slog.Logger) *WebSocket {
+	p := &WebSocket{
+		route:  route,
+		pool:   pool,
+		logger: logger,
+		dialer: websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: route.Timeout,
+		},
+		conns: make(map[*websocket.Conn]struct{}),
+	}
+	p.upgrader = websocket.Upgrader{
+		HandshakeTimeout: route.Timeout,
+		CheckOrigin:      p.checkOrigin,
+	}
+	return p
+}
+
+// Active is the number of open relays, counting the ones still dialing.
+func (p *WebSocket) Active() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active
+}
+
+// reserve takes a slot for a new relay, it fails once the route has MaxConnections.
+func (p *WebSocket) reserve() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.route.MaxConnections > 0 && p.active >= p.route.MaxConnections {
+		return p.active, false
+	}
+	p.active++
+	return p.active, true
+}
+
+func (p *WebSocket) release() {
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+}
+
+func (p *WebSocket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if !p.checkOrigin(r) {
+		p.logger.Warn("origin not allowed", "origin", r.Header.Get("Origin"))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if active, ok := p.reserve(); !ok {
+		p.logger.Warn("too many websocket connections", "active", active)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.release()
+
+	u, err := p.pool.PickSticky(r.Header.Get(UserIDHeader))
+	if err != nil {
+		p.logger.Error("failed to pick upstream", "error", err)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.pool.Release(u)
+
+	backendConn, resp, err := p.dialer.DialContext(r.Context(), u.URL.String(), p.upstreamHeader(r))
+	if err != nil {
+		if resp != nil && resp.StatusCode < http.StatusInternalServerError {
+			// the upstream is up and refused the handshake, pass its answer on
+			p.pool.Success(u)
+			http.Error(w, http.StatusText(resp.StatusCode), resp.StatusCode)
+			return
+		}
+		p.logger.Error("failed to dial upstream", "error", err, "upstream", u.URL.String())
+		p.pool.Failure(u, err)
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+		return
+	}
+	p.pool.Success(u)
+
+	responseHeader := http.Header{}
+	if protocol := backendConn.Subprotocol(); protocol != "" {
+		responseHeader.Set("Sec-Websocket-Protocol", protocol)
+	}
+	clientConn, err := p.upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		// Upgrade already answered the client
+		p.logger.Error("failed to upgrade connection", "error", err)
+		backendConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(writeWait))
+		backendConn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	p.conns[clientConn] = struct{}{}
+	p.mu.Unlock()
+	p.logger.Debug("relay opened", "upstream", u.URL.String(), "active", p.Active())
+	opened := time.Now()
+
+	// the upstream stays counted on the pool while the relay runs
+	p.relay(clientConn, backendConn)
+
+	p.mu.Lock()
+	delete(p.conns, clientConn)
+	p.mu.Unlock()
+	p.logger.Debug("relay closed", "upstream", u.URL.String(), "duration", time.Since(opened))
+}
+
+// relay copies frames both ways until one side closes, then gives the other side
+// writeWait to answer the close handshake before both connections are closed.
+func (p *WebSocket) relay(clientConn, backendConn *websocket.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		p.pump(clientConn, backendConn, websocket.CloseGoingAway)
+		done <- struct{}{}
+	}()
+	go func() {
+		p.pump(backendConn, clientConn, upstreamLost)
+		done <- struct{}{}
+	}()
+
+	<-done
+	timer := time.NewTimer(writeWait)
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+	timer.Stop()
+	clientConn.Close()
+	backendConn.Close()
+}
+
+// pump reads src and writes dst until src fails. Pings and pongs are forwarded
+// instead of answered, so keepalive stays between the client and the upstream, and
+// every frame extends the idle deadline of src. When src is gone without a close
+// frame dst is closed with lostCode.
+func (p *WebSocket) pump(src, dst *websocket.Conn, lostCode int) {
+	idle := p.route.IdleTimeout
+	src.SetReadDeadline(time.Now().Add(idle))
+	src.SetPingHandler(func(data string) error {
+		src.SetReadDeadline(time.Now().Add(idle))
+		return ignoreCloseSent(dst.WriteControl(websocket.PingMessage, []byte(data), time.Now().Add(writeWait)))
+	})
+	src.SetPongHandler(func(data string) error {
+		src.SetReadDeadline(time.Now().Add(idle))
+		return ignoreCloseSent(dst.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait)))
+	})
+	// the close frame is passed on by the read error below, not echoed here
+	src.SetCloseHandler(func(int, string) error { return nil })
+
+	for {
+		mt, message, err := src.ReadMessage()
+		if err != nil {
+			dst.WriteControl(websocket.CloseMessage, closeFrame(err, lostCode), time.Now().Add(writeWait))
+			return
+		}
+		src.SetReadDeadline(time.Now().Add(idle))
+		dst.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := dst.WriteMessage(mt, message); err != nil {
+			return
+		}
+	}
+}
+
+// closeFrame is the close frame passed on for a read error: the received code and
+// text, going away on idle timeout and lostCode when the peer vanished.
+func closeFrame(err error, lostCode int) []byte {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		switch closeErr.Code {
+		case websocket.CloseNoStatusReceived:
+			return websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		case websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake:
+			return websocket.FormatCloseMessage(lostCode, "")
+		default:
+			return websocket.FormatCloseMessage(closeErr.Code, closeErr.Text)
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return websocket.FormatCloseMessage(websocket.CloseGoingAway, "idle timeout")
+	}
+	return websocket.FormatCloseMessage(lostCode, "")
+}
+
+func ignoreCloseSent(err error) error {
+	if errors.Is(err, websocket.ErrCloseSent) {
+		return nil
+	}
+	return err
+}
+
+// checkOrigin accepts clients without an Origin header, the gateway host itself and
+// the allowed origins of the route.
+func (p *WebSocket) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(p.route.AllowedOrigins, "*") || slices.Contains(p.route.AllowedOrigins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func (p *WebSocket) upstreamHeader(r *http.Request) http.Header {
+	header := http.Header{}
+	for _, name := range forwardedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		header.Set("X-Real-IP", clientIP)
+		if prior := header.Get("X-Forwarded-For"); prior != "" {
+			clientIP = prior + ", " + clientIP
+		}
+		header.Set("X-Forwarded-For", clientIP)
+	}
+	if protocols := websocket.Subprotocols(r); len(protocols) > 0 {
+		header.Set("Sec-Websocket-Protocol", strings.Join(protocols, ", "))
+	}
+	return header
+}
+
+// CloseAll sends a going away close frame to every relayed client and closes the
+// connection, the relays close the upstream side.
+func (p *WebSocket) CloseAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	closeFrame := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down, reconnect")
+	for conn := range p.conns {
+		conn.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(writeWait))
+		conn.Close()
+	}
+}
@@ -0,0 +1,356 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"messenger-gateway/internal/balancer"
+	"messenger-gateway/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// backend is an upstream that upgrades every request and hands the connection to serve.
+type backend struct {
+	*httptest.Server
+	upgrades atomic.Int64
+}
+
+func newBackend(t *testing.T, serve func(conn *websocket.Conn)) *backend {
+	t.Helper()
+	b := &backend{}
+	upgrader := websocket.Upgrader{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		b.upgrades.Add(1)
+		defer conn.Close()
+		serve(conn)
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func wsURL(s *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func testRoute(upstream string) config.Route {
+	return config.Route{
+		Prefix:      "/ws",
+		Upstreams:   []string{upstream},
+		Type:        config.RouteWebsocket,
+		Timeout:     time.Second,
+		MaxFails:    3,
+		FailTimeout: time.Second,
+		IdleTimeout: time.Minute,
+	}
+}
+
+// newProxy serves a WebSocket relay for route and returns its ws:// url.
+func newProxy(t *testing.T, route config.Route) (*WebSocket, string) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pool, err := balancer.NewPool(route, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewWebSocket(route, pool, logger)
+	s := httptest.NewServer(p)
+	t.Cleanup(s.Close)
+	return p, wsURL(s)
+}
+
+// echo sends every message back until the client goes away.
+func echo(conn *websocket.Conn) {
+	for {
+		mt, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(mt, message); err != nil {
+			return
+		}
+	}
+}
+
+func dial(t *testing.T, url string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dial: %v, status %d", err, status)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// dialStatus dials a handshake that must be refused and returns the http status.
+func dialStatus(t *testing.T, url string, header http.Header) int {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil {
+		conn.Close()
+		t.Fatal("handshake accepted")
+	}
+	if resp == nil {
+		t.Fatalf("no http answer: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestWebSocketRelaysMessages(t *testing.T) {
+	b := newBackend(t, echo)
+	_, url := newProxy(t, testRoute(wsURL(b.Server)))
+
+	conn := dial(t, url, nil)
+	for _, mt := range []int{websocket.TextMessage, websocket.BinaryMessage} {
+		if err := conn.WriteMessage(mt, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		got, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != mt || string(message) != "hello" {
+			t.Errorf("got type %d %q, want type %d %q", got, message, mt, "hello")
+		}
+	}
+}
+
+func TestWebSocketDialFailure(t *testing.T) {
+	// a listener closed right away leaves an address nobody answers on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := "ws://" + l.Addr().String()
+	l.Close()
+	_, url := newProxy(t, testRoute(upstream))
+
+	if status := dialStatus(t, url, nil); status != http.StatusBadGateway {
+		t.Errorf("status %d, want %d", status, http.StatusBadGateway)
+	}
+}
+
+func TestWebSocketUpstreamRefusal(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	t.Cleanup(upstream.Close)
+	_, url := newProxy(t, testRoute(wsURL(upstream)))
+
+	if status := dialStatus(t, url, nil); status != http.StatusUnauthorized {
+		t.Errorf("status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	b := newBackend(t, echo)
+	route := testRoute(wsURL(b.Server))
+	route.AllowedOrigins = []string{"https://app.example"}
+	_, url := newProxy(t, route)
+
+	if status := dialStatus(t, url, http.Header{"Origin": {"https://evil.example"}}); status != http.StatusForbidden {
+		t.Errorf("status %d, want %d", status, http.StatusForbidden)
+	}
+	if n := b.upgrades.Load(); n != 0 {
+		t.Errorf("upstream dialed %d times for a refused origin", n)
+	}
+	dial(t, url, http.Header{"Origin": {"https://app.example"}})
+	dial(t, url, nil)
+}
+
+func TestWebSocketCloseFromUpstream(t *testing.T) {
+	b := newBackend(t, func(conn *websocket.Conn) {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "bye"), time.Now().Add(time.Second))
+		conn.ReadMessage()
+	})
+	_, url := newProxy(t, testRoute(wsURL(b.Server)))
+
+	conn := dial(t, url, nil)
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, 4001) || err.(*websocket.CloseError).Text != "bye" {
+		t.Errorf("got %v, want close 4001 bye", err)
+	}
+}
+
+func TestWebSocketCloseFromClient(t *testing.T) {
+	closed := make(chan error, 1)
+	b := newBackend(t, func(conn *websocket.Conn) {
+		_, _, err := conn.ReadMessage()
+		closed <- err
+	})
+	_, url := newProxy(t, testRoute(wsURL(b.Server)))
+
+	conn := dial(t, url, nil)
+	if err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4002, "done"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-closed:
+		if !websocket.IsCloseError(err, 4002) || err.(*websocket.CloseError).Text != "done" {
+			t.Errorf("upstream got %v, want close 4002 done", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream never got the close frame")
+	}
+}
+
+func TestWebSocketUpstreamLost(t *testing.T) {
+	b := newBackend(t, func(conn *websocket.Conn) {
+		// gone without a close frame
+		conn.UnderlyingConn().Close()
+	})
+	_, url := newProxy(t, testRoute(wsURL(b.Server)))
+
+	conn := dial(t, url, nil)
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, upstreamLost) {
+		t.Errorf("got %v, want close %d", err, upstreamLost)
+	}
+}
+
+func TestWebSocketPingPong(t *testing.T) {
+	pinged := make(chan string, 1)
+	b := newBackend(t, func(conn *websocket.Conn) {
+		conn.SetPingHandler(func(data string) error {
+			pinged <- data
+			return conn.WriteControl(websocket.PongMessage, []byte("pong "+data), time.Now().Add(time.Second))
+		})
+		conn.ReadMessage()
+	})
+	_, url := newProxy(t, testRoute(wsURL(b.Server)))
+
+	conn := dial(t, url, nil)
+	ponged := make(chan string, 1)
+	conn.SetPongHandler(func(data string) error {
+		ponged <- data
+		return nil
+	})
+	go conn.ReadMessage()
+	if err := conn.WriteControl(websocket.PingMessage, []byte("1"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, pinged, "ping"); got != "1" {
+		t.Errorf("upstream got ping %q, want %q", got, "1")
+	}
+	if got := receive(t, ponged, "pong"); got != "pong 1" {
+		t.Errorf("client got pong %q, want %q", got, "pong 1")
+	}
+}
+
+func receive(t *testing.T, ch chan string, what string) string {
+	t.Helper()
+	select {
+	case got := <-ch:
+		return got
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s not forwarded", what)
+		return ""
+	}
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	closed := make(chan error, 1)
+	b := newBackend(t, func(conn *websocket.Conn) {
+		_, _, err := conn.ReadMessage()
+		closed <- err
+	})
+	route := testRoute(wsURL(b.Server))
+	route.IdleTimeout = 200 * time.Millisecond
+	p, url := newProxy(t, route)
+
+	conn := dial(t, url, nil)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) || err.(*websocket.CloseError).Text != "idle timeout" {
+		t.Errorf("client got %v, want going away on idle timeout", err)
+	}
+	if err := <-closed; !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("upstream got %v, want going away", err)
+	}
+	waitActive(t, p, 0)
+}
+
+func TestWebSocketMaxConnections(t *testing.T) {
+	b := newBackend(t, echo)
+	route := testRoute(wsURL(b.Server))
+	route.MaxConnections = 1
+	p, url := newProxy(t, route)
+
+	first := dial(t, url, nil)
+	if status := dialStatus(t, url, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", status, http.StatusServiceUnavailable)
+	}
+	first.Close()
+	waitActive(t, p, 0)
+	dial(t, url, nil)
+}
+
+// TestWebSocketMaxConnectionsConcurrent opens many relays at once against a slow
+// handshake, the cap must hold while they are all dialing.
+func TestWebSocketMaxConnectionsConcurrent(t *testing.T) {
+	const maxConnections = 3
+	release := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	route := testRoute(wsURL(slow))
+	route.MaxConnections = maxConnections
+	_, url := newProxy(t, route)
+
+	var wg sync.WaitGroup
+	var opened, refused atomic.Int64
+	for range 20 {
+		wg.Go(func() {
+			conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+			switch {
+			case err == nil:
+				opened.Add(1)
+				t.Cleanup(func() { conn.Close() })
+			case resp != nil && resp.StatusCode == http.StatusServiceUnavailable:
+				refused.Add(1)
+			default:
+				t.Error(errors.Join(errors.New("unexpected dial result"), err))
+			}
+		})
+	}
+	wg.Wait()
+	close(release)
+	if opened.Load() != maxConnections || refused.Load() != 20-maxConnections {
+		t.Errorf("opened %d and refused %d, want %d and %d", opened.Load(), refused.Load(), maxConnections, 20-maxConnections)
+	}
+}
+
+func waitActive(t *testing.T, p *WebSocket, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Active() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d relays active, want %d", p.Active(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"messenger-gateway/internal/proxy"
+	"messenger-shared/jwt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// authenticate rejects requests to non public paths without a valid access token
+// before they reach a backend, websocket upgrades included.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(proxy.UserIDHeader)
+		if s.isPublic(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tokenStr, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			unauthorized(w)
+			return
+		}
+		id, err := jwt.ParseToken(s.keys, tokenStr)
+		if err != nil {
+			s.logger.Debug("failed to parse token", "error", err, "path", r.URL.Path)
+			unauthorized(w)
+			return
+		}
+		r.Header.Set(proxy.UserIDHeader, strconv.FormatUint(id, 10))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) isPublic(path string) bool {
+	for _, public := range s.config.PublicPaths {
+		if path == public || (strings.HasSuffix(public, "/") && strings.HasPrefix(path, public)) {
+			return true
+		}
+	}
+	return false
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="messenger"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"math"
+	"messenger-gateway/internal/proxy"
+	"messenger-shared/ratelimit"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// limitByIP throttles every request per client IP, a path with its own limit takes
+// from its own bucket as well.
+func (s *Server) limitByIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if !s.allow(w, r, "gw:ip:"+ip, s.config.RateLimit.PerIP) {
+			return
+		}
+		for _, path := range s.config.RateLimit.Paths {
+			if r.URL.Path == path.Path && !s.allow(w, r, "gw:ip:"+ip+":"+path.Path, path.Limit) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitByUser throttles authenticated requests per user, it runs after authenticate.
+func (s *Server) limitByUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get(proxy.UserIDHeader); id != "" && !s.allow(w, r, "gw:user:"+id, s.config.RateLimit.PerUser) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token from the bucket of key and answers 429 when it is empty. A store
+// error lets the request through, the store logs it.
+func (s *Server) allow(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+	ok, wait, err := s.limits.Allow(r.Context(), key, limit)
+	if err != nil || ok {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return false
+}
@@ -1,25 +1,129 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"messenger-gateway/internal/balancer"
 	"messenger-gateway/internal/config"
+	"messenger-gateway/internal/proxy"
+	"messenger-shared/jwt"
+	"messenger-shared/ratelimit"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// readTimeout and writeTimeout bound reading the request and writing the answer on
+// http routes. They are set per request because server-wide timeouts would cut the
+// websocket relays.
+const (
+	readTimeout  = 15 * time.Second
+	writeTimeout = 15 * time.Second
+)
+
 type Server struct {
 	config *config.Config
 	router *mux.Router
+	http   *http.Server
+	relays []*proxy.WebSocket
+	pools  []*balancer.Pool
+	// checks is the context of the health checks of the pools, stopChecks cancels it.
+	checks     context.Context
+	stopChecks context.CancelFunc
+	keys       *jwt.JWKSCache
+	limits     ratelimit.Store
+	logger     *slog.Logger
+}
+
+func NewServer(cfg *config.Config, keys *jwt.JWKSCache, limits ratelimit.Store, logger *slog.Logger) (*Server, error) {
+	checks, stopChecks := context.WithCancel(context.Background())
+	s := &Server{
+		config:     cfg,
+		router:     mux.NewRouter(),
+		checks:     checks,
+		stopChecks: stopChecks,
+		keys:       keys,
+		limits:     limits,
+		logger:     logger,
+	}
+	s.router.Use(s.limitByIP, s.authenticate, s.limitByUser)
+	if err := s.registerRoutes(); err != nil {
+		stopChecks()
+		return nil, err
+	}
+	s.http = &http.Server{
+		Addr:              fmt.Sprintf("%s:%v", cfg.Hostname, cfg.Port),
+		Handler:           s.router,
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	// hijacked websocket connections are not tracked by the server, close them ourselves
+	s.http.RegisterOnShutdown(s.closeRelays)
+	return s, nil
 }
 
-func NewServer(cfg *config.Config) *Server {
-	return &Server{
-		config: cfg,
-		router: mux.NewRouter(),
+// registerRoutes adds the routing table to the router, mux matches in registration order
+// so longer prefixes go first.
+func (s *Server) registerRoutes() error {
+	routes := append([]config.Route(nil), s.config.Routes...)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Prefix) > len(routes[j].Prefix)
+	})
+	for _, route := range routes {
+		logger := s.logger.With("route", route.Prefix)
+		pool, err := balancer.NewPool(route, logger)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", route.Prefix, err)
+		}
+		s.pools = append(s.pools, pool)
+		var handler http.Handler
+		switch route.Type {
+		case config.RouteWebsocket:
+			relay := proxy.NewWebSocket(route, pool, logger)
+			s.relays = append(s.relays, relay)
+			handler = relay
+		default:
+			handler = withDeadlines(proxy.NewHTTP(route, pool, logger), route.Timeout)
+		}
+		s.router.PathPrefix(route.Prefix).Handler(handler)
+		s.logger.Debug("route registered", "prefix", route.Prefix, "type", route.Type, "upstreams", route.Upstreams, "balance", route.Balance)
 	}
+	return nil
+}
+
+// withDeadlines gives the request readTimeout to be read and writeTimeout to be
+// answered, or the route timeout when it is longer, so slow uploads and upstreams get
+// the time their route allows. The write deadline is cleared afterwards, it would
+// outlive the request on a kept-alive connection later upgraded to a websocket.
+func withDeadlines(next http.Handler, timeout time.Duration) http.Handler {
+	read, write := max(readTimeout, timeout), max(writeTimeout, timeout)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		now := time.Now()
+		_ = rc.SetReadDeadline(now.Add(read))
+		_ = rc.SetWriteDeadline(now.Add(write))
+		defer rc.SetWriteDeadline(time.Time{})
+		next.ServeHTTP(w, r)
+	})
 }
 
 func (s *Server) ServeHttp() error {
-	return http.ListenAndServe(fmt.Sprintf("%s:%v", s.config.Hostname, s.config.Port), s.router)
+	for _, pool := range s.pools {
+		go pool.RunHealthChecks(s.checks)
+	}
+	return s.http.ListenAndServe()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopChecks()
+	return s.http.Shutdown(ctx)
+}
+
+func (s *Server) closeRelays() {
+	for _, relay := range s.relays {
+		relay.CloseAll()
+	}
 }
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE chat_users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'member'
+    CHECK (role IN ('owner', 'admin', 'member', 'read_only'));
+
+UPDATE chat_users SET role = 'owner'
+FROM chats
+WHERE chats.id = chat_users.chat_id AND chats.creator_id = chat_users.user_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chat_users_owner ON chat_users (chat_id) WHERE role = 'owner';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_chat_users_owner;
+ALTER TABLE chat_users DROP COLUMN IF EXISTS role;
+-- +goose StatementEnd
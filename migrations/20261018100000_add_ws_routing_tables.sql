@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS ws_nodes (
+    node_id TEXT PRIMARY KEY,
+    heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS ws_routes (
+    node_id TEXT NOT NULL REFERENCES ws_nodes(node_id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL,
+    PRIMARY KEY (node_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ws_routes_user_id ON ws_routes (user_id);
+
+CREATE TABLE IF NOT EXISTS ws_payloads (
+    id BIGSERIAL PRIMARY KEY,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ws_payloads;
+DROP TABLE IF EXISTS ws_routes;
+DROP TABLE IF EXISTS ws_nodes;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE chats
+    ADD COLUMN IF NOT EXISTS type TEXT NOT NULL DEFAULT 'group' CHECK (type IN ('group', 'direct')),
+    ADD COLUMN IF NOT EXISTS direct_key TEXT;
+
+-- one direct chat per pair of users, the key is "<smaller id>:<larger id>"
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chats_direct_key ON chats (direct_key) WHERE direct_key IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_chats_direct_key;
+
+ALTER TABLE chats
+    DROP COLUMN IF EXISTS direct_key,
+    DROP COLUMN IF EXISTS type;
+-- +goose StatementEnd
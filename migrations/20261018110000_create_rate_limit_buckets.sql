@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- token buckets shared by replicas, tat is the time the bucket is full again
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key TEXT PRIMARY KEY,
+    tat TIMESTAMPTZ NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS rate_limit_buckets;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- replies outlive the root of their thread as plain messages, a cascade would delete
+-- them without a MessageDeleted event reaching the members
+ALTER TABLE messages
+    DROP CONSTRAINT IF EXISTS messages_thread_root_id_fkey,
+    ADD CONSTRAINT messages_thread_root_id_fkey FOREIGN KEY (thread_root_id) REFERENCES messages(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE messages
+    DROP CONSTRAINT IF EXISTS messages_thread_root_id_fkey,
+    ADD CONSTRAINT messages_thread_root_id_fkey FOREIGN KEY (thread_root_id) REFERENCES messages(id) ON DELETE CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+-- storage keys of deleted attachments, the attachment sweeper removes their blobs;
+-- the trigger catches the cascades from messages, chats and users as well
+CREATE TABLE IF NOT EXISTS deleted_blobs (
+    storage_key TEXT PRIMARY KEY,
+    deleted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION queue_deleted_blob() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO deleted_blobs (storage_key) VALUES (OLD.storage_key) ON CONFLICT DO NOTHING;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER attachments_queue_deleted_blob
+    AFTER DELETE ON attachments
+    FOR EACH ROW EXECUTE FUNCTION queue_deleted_blob();
+
+CREATE INDEX IF NOT EXISTS idx_attachments_unlinked ON attachments (created_at) WHERE message_id IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_attachments_unlinked;
+
+DROP TRIGGER IF EXISTS attachments_queue_deleted_blob ON attachments;
+
+DROP FUNCTION IF EXISTS queue_deleted_blob();
+
+DROP TABLE IF EXISTS deleted_blobs;
+-- +goose StatementEnd
@@ -0,0 +1,162 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minRefreshInterval limits how often an unknown kid can trigger a fetch.
+const minRefreshInterval = 30 * time.Second
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// JWKSCache fetches the public keys auth_service publishes and caches them. Keys are
+// refetched after refreshInterval, or earlier when a token is signed with an unknown
+// key after a rotation. Fetches run without holding mu, lookups of known keys never
+// wait on one.
+type JWKSCache struct {
+	url             string
+	refreshInterval time.Duration
+	client          *http.Client
+	mu              sync.Mutex
+	keys            map[string]*rsa.PublicKey
+	fetchedAt       time.Time
+	// fetching is the fetch in flight, nil while there is none. Callers needing fresh
+	// keys meanwhile wait for it instead of starting their own.
+	fetching *fetch
+}
+
+type fetch struct {
+	done chan struct{}
+	err  error
+}
+
+func NewJWKSCache(url string, refreshInterval time.Duration) *JWKSCache {
+	return &JWKSCache{
+		url:             url,
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		keys:            make(map[string]*rsa.PublicKey),
+	}
+}
+
+func (c *JWKSCache) Key(kid string) (*rsa.PublicKey, error) {
+	key, ok, stale, due := c.lookup(kid)
+	if ok && !stale {
+		return key, nil
+	}
+	if stale || due {
+		if err := c.refresh(context.Background()); err != nil && !ok {
+			return nil, err
+		}
+		key, ok, _, _ = c.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// lookup returns the cached key, whether the cache is past refreshInterval and whether
+// an unknown key may trigger a fetch yet.
+func (c *JWKSCache) lookup(kid string) (*rsa.PublicKey, bool, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.keys[kid]
+	age := time.Since(c.fetchedAt)
+	return key, ok, age > c.refreshInterval, age > minRefreshInterval
+}
+
+// Refresh fetches the keys right away, it is meant to warm the cache on start.
+func (c *JWKSCache) Refresh(ctx context.Context) error {
+	return c.refresh(ctx)
+}
+
+// refresh fetches the keys and swaps them in, or waits for the fetch already in flight.
+func (c *JWKSCache) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	if f := c.fetching; f != nil {
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f := &fetch{done: make(chan struct{})}
+	c.fetching = f
+	c.mu.Unlock()
+
+	keys, err := c.fetch(ctx)
+	c.mu.Lock()
+	if err == nil {
+		c.keys = keys
+		c.fetchedAt = time.Now()
+	}
+	c.fetching = nil
+	c.mu.Unlock()
+	f.err = err
+	close(f.done)
+	return err
+}
+
+func (c *JWKSCache) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks: %s", resp.Status)
+	}
+	var set jwks
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		key, err := parseRSAKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func parseRSAKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// syncQuery books the tokens taken since the last sync and returns the buckets as all
+// replicas booked them. A bucket the replicas overdrew together stays empty until the
+// extra tokens have refilled as well.
+const syncQuery = `
+	INSERT INTO rate_limit_buckets AS b (key, tat)
+	SELECT key, now() + make_interval(secs => cost) FROM unnest($1::text[], $2::float8[]) AS t(key, cost)
+	ON CONFLICT (key) DO UPDATE SET tat = GREATEST(b.tat, now()) + (EXCLUDED.tat - now())
+	RETURNING key, tat`
+
+// Batched takes tokens from buckets in memory and books them in rate_limit_buckets
+// once per sync, so replicas share the buckets without a round trip per request.
+// Between two syncs each replica may let up to a burst more through.
+type Batched struct {
+	local  *Memory
+	db     *pgxpool.Pool
+	logger *slog.Logger
+	mu     sync.Mutex
+	// taken is the refill time of the tokens not booked in the database yet, per key.
+	taken map[string]time.Duration
+	swept time.Time
+}
+
+func NewBatched(databaseUrl string, logger *slog.Logger) (*Batched, error) {
+	db, err := pgxpool.New(context.Background(), databaseUrl)
+	if err != nil {
+		logger.Error("failed connect to database", "error", err)
+		return nil, err
+	}
+	return &Batched{local: NewMemory(), db: db, logger: logger, taken: make(map[string]time.Duration), swept: time.Now()}, nil
+}
+
+func (b *Batched) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	ok, wait, err := b.local.Allow(ctx, key, limit)
+	if !ok || limit.Unlimited() {
+		return ok, wait, err
+	}
+	b.mu.Lock()
+	b.taken[key] += limit.interval()
+	b.mu.Unlock()
+	return true, 0, nil
+}
+
+// Run syncs the buckets every interval until ctx is done.
+func (b *Batched) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.sync(ctx)
+		}
+	}
+}
+
+// sync books the taken tokens and catches the local buckets up with the tokens
+// other replicas took. Tokens that fail to book are dropped, the limit is loose
+// for that interval.
+func (b *Batched) sync(ctx context.Context) {
+	b.mu.Lock()
+	pending := b.taken
+	b.taken = make(map[string]time.Duration)
+	b.mu.Unlock()
+
+	if time.Since(b.swept) >= sweepInterval {
+		b.swept = time.Now()
+		if _, err := b.db.Exec(ctx, "DELETE FROM rate_limit_buckets WHERE tat < now()"); err != nil {
+			b.logger.Error("failed to sweep buckets", "error", err)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(pending))
+	costs := make([]float64, 0, len(pending))
+	for key, cost := range pending {
+		keys = append(keys, key)
+		costs = append(costs, cost.Seconds())
+	}
+	rows, err := b.db.Query(ctx, syncQuery, keys, costs)
+	if err != nil {
+		b.logger.Error("failed to sync buckets", "error", err, "buckets", len(keys))
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var tat time.Time
+		if err := rows.Scan(&key, &tat); err != nil {
+			b.logger.Error("failed to scan bucket", "error", err)
+			return
+		}
+		b.local.catchUp(key, tat)
+	}
+	if err := rows.Err(); err != nil {
+		b.logger.Error("failed to sync buckets", "error", err, "buckets", len(keys))
+	}
+}
+
+func (b *Batched) Close() {
+	b.db.Close()
+}
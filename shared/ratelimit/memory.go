@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps the buckets of a single process.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]time.Time
+	swept   time.Time
+	now     func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]time.Time), swept: time.Now(), now: time.Now}
+}
+
+func (m *Memory) Allow(_ context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	if limit.Unlimited() {
+		return true, 0, nil
+	}
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	tat := m.buckets[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(limit.interval())
+	if wait := next.Sub(now) - limit.tolerance(); wait > 0 {
+		return false, wait, nil
+	}
+	m.buckets[key] = next
+	return true, 0, nil
+}
+
+// Forget drops the bucket of key, for keys that are never used again.
+func (m *Memory) Forget(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.buckets, key)
+}
+
+// catchUp books the bucket of key up to tat, when others took tokens from it.
+func (m *Memory) catchUp(key string, tat time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tat.After(m.buckets[key]) {
+		m.buckets[key] = tat
+	}
+}
+
+// sweep drops the buckets that are full again, a missing bucket is a full one.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.swept) < sweepInterval {
+		return
+	}
+	for key, tat := range m.buckets {
+		if tat.Before(now) {
+			delete(m.buckets, key)
+		}
+	}
+	m.swept = now
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clock is a time that moves only when the test says so.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestMemory() (*Memory, *clock) {
+	c := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := NewMemory()
+	m.now = c.Now
+	return m, c
+}
+
+func take(t *testing.T, m *Memory, key string, limit Limit) (bool, time.Duration) {
+	t.Helper()
+	ok, wait, err := m.Allow(context.Background(), key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok, wait
+}
+
+func TestMemoryBurst(t *testing.T) {
+	m, _ := newTestMemory()
+	limit := Limit{Rate: 1, Burst: 5}
+	for i := range limit.Burst {
+		if ok, _ := take(t, m, "a", limit); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	if ok, _ := take(t, m, "a", limit); ok {
+		t.Error("request over the burst allowed")
+	}
+	if ok, _ := take(t, m, "b", limit); !ok {
+		t.Error("another key shares the bucket")
+	}
+}
+
+func TestMemoryRefill(t *testing.T) {
+	m, c := newTestMemory()
+	limit := Limit{Rate: 2, Burst: 4}
+	for range limit.Burst {
+		take(t, m, "a", limit)
+	}
+	// one token refills every 500ms
+	c.now = c.now.Add(499 * time.Millisecond)
+	if ok, _ := take(t, m, "a", limit); ok {
+		t.Error("allowed before a token refilled")
+	}
+	c.now = c.now.Add(time.Millisecond)
+	if ok, _ := take(t, m, "a", limit); !ok {
+		t.Error("refused after a token refilled")
+	}
+	if ok, _ := take(t, m, "a", limit); ok {
+		t.Error("allowed more than the refilled token")
+	}
+	// an idle bucket fills up to the burst, not beyond
+	c.now = c.now.Add(time.Hour)
+	for i := range limit.Burst {
+		if ok, _ := take(t, m, "a", limit); !ok {
+			t.Fatalf("request %d after refill refused", i+1)
+		}
+	}
+	if ok, _ := take(t, m, "a", limit); ok {
+		t.Error("idle bucket filled beyond the burst")
+	}
+}
+
+func TestMemoryRetryAfter(t *testing.T) {
+	m, c := newTestMemory()
+	limit := Limit{Rate: 4, Burst: 2}
+	take(t, m, "a", limit)
+	take(t, m, "a", limit)
+	ok, wait := take(t, m, "a", limit)
+	if ok || wait != 250*time.Millisecond {
+		t.Fatalf("got %v and wait %v, want refused and wait 250ms", ok, wait)
+	}
+	c.now = c.now.Add(100 * time.Millisecond)
+	if _, wait := take(t, m, "a", limit); wait != 150*time.Millisecond {
+		t.Errorf("wait %v after 100ms, want 150ms", wait)
+	}
+	// a refused request takes no token, waiting as told is enough
+	c.now = c.now.Add(150 * time.Millisecond)
+	if ok, _ := take(t, m, "a", limit); !ok {
+		t.Error("refused after waiting as told")
+	}
+}
+
+func TestMemoryUnlimited(t *testing.T) {
+	m, _ := newTestMemory()
+	for range 100 {
+		if ok, _ := take(t, m, "a", Limit{}); !ok {
+			t.Fatal("zero rate limited")
+		}
+	}
+}
+
+func TestMemoryCatchUp(t *testing.T) {
+	m, c := newTestMemory()
+	limit := Limit{Rate: 1, Burst: 3}
+	take(t, m, "a", limit)
+	// other replicas took the rest of the burst
+	m.catchUp("a", c.now.Add(3*time.Second))
+	if ok, wait := take(t, m, "a", limit); ok || wait != time.Second {
+		t.Errorf("got %v and wait %v, want refused and wait 1s", ok, wait)
+	}
+	// an older booking doesn't give tokens back
+	m.catchUp("a", c.now)
+	if ok, _ := take(t, m, "a", limit); ok {
+		t.Error("catching up with an older booking refilled the bucket")
+	}
+}
+
+func TestMemoryForget(t *testing.T) {
+	m, _ := newTestMemory()
+	limit := Limit{Rate: 1, Burst: 1}
+	take(t, m, "a", limit)
+	m.Forget("a")
+	if ok, _ := take(t, m, "a", limit); !ok {
+		t.Error("forgotten bucket still empty")
+	}
+	if len(m.buckets) != 1 {
+		t.Errorf("%d buckets kept, want 1", len(m.buckets))
+	}
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// allowQuery books the next token only when the bucket isn't booked further ahead
+// than the burst allows, no row is returned when the bucket is empty.
+const allowQuery = `
+	INSERT INTO rate_limit_buckets AS b (key, tat) VALUES ($1, now() + make_interval(secs => $2))
+	ON CONFLICT (key) DO UPDATE SET tat = GREATEST(b.tat, now()) + make_interval(secs => $2)
+	WHERE GREATEST(b.tat, now()) + make_interval(secs => $2) <= now() + make_interval(secs => $3)
+	RETURNING tat`
+
+const waitQuery = `
+	SELECT EXTRACT(EPOCH FROM GREATEST(tat, now()) + make_interval(secs => $2) - make_interval(secs => $3) - now())::float8
+	FROM rate_limit_buckets WHERE key = $1`
+
+// Postgres keeps the buckets in rate_limit_buckets, so every replica takes tokens
+// from the same bucket.
+type Postgres struct {
+	db     *pgxpool.Pool
+	logger *slog.Logger
+	mu     sync.Mutex
+	swept  time.Time
+}
+
+func NewPostgres(databaseUrl string, logger *slog.Logger) (*Postgres, error) {
+	db, err := pgxpool.New(context.Background(), databaseUrl)
+	if err != nil {
+		logger.Error("failed connect to database", "error", err)
+		return nil, err
+	}
+	return &Postgres{db: db, logger: logger, swept: time.Now()}, nil
+}
+
+func (p *Postgres) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	if limit.Unlimited() {
+		return true, 0, nil
+	}
+	p.sweep()
+	interval, tolerance := limit.interval().Seconds(), limit.tolerance().Seconds()
+	var tat time.Time
+	err := p.db.QueryRow(ctx, allowQuery, key, interval, tolerance).Scan(&tat)
+	if err == nil {
+		return true, 0, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		p.logger.Error("failed to take token", "error", err, "key", key)
+		return false, 0, err
+	}
+	var wait float64
+	if err := p.db.QueryRow(ctx, waitQuery, key, interval, tolerance).Scan(&wait); err != nil {
+		p.logger.Error("failed to get wait time", "error", err, "key", key)
+		return false, 0, err
+	}
+	return false, time.Duration(wait * float64(time.Second)), nil
+}
+
+// sweep deletes the buckets that are full again in the background, at most once
+// per sweepInterval.
+func (p *Postgres) sweep() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.swept) < sweepInterval {
+		return
+	}
+	p.swept = time.Now()
+	go func() {
+		if _, err := p.db.Exec(context.Background(), "DELETE FROM rate_limit_buckets WHERE tat < now()"); err != nil {
+			p.logger.Error("failed to sweep buckets", "error", err)
+		}
+	}()
+}
+
+func (p *Postgres) Close() {
+	p.db.Close()
+}
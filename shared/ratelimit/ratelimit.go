@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// sweepInterval is how often a store forgets buckets that are full again.
+const sweepInterval = time.Minute
+
+// Limit is a token bucket refilled with Rate tokens per second up to Burst tokens,
+// every allowed request takes one token. A zero Rate means no limit.
+type Limit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// interval is the time one token takes to refill.
+func (l Limit) interval() time.Duration {
+	return time.Duration(float64(time.Second) / l.Rate)
+}
+
+// tolerance is how far ahead of now a bucket may be booked, the time Burst tokens
+// take to refill.
+func (l Limit) tolerance() time.Duration {
+	return l.interval() * time.Duration(max(l.Burst, 1))
+}
+
+// Store keeps the buckets. A bucket is stored as the time it is full again (GCRA),
+// so taking a token is a single compare and set of one timestamp.
+type Store interface {
+	// Allow takes a token from the bucket of key, when the bucket is empty it returns
+	// false and how long until the next token.
+	Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
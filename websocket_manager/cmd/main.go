@@ -2,10 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"log/slog"
+	"messenger-shared/jwt"
+	"messenger-shared/ratelimit"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"websocket_manager/internal/attachments"
+	"websocket_manager/internal/blob"
+	"websocket_manager/internal/broker"
 	"websocket_manager/internal/config"
 	"websocket_manager/internal/server"
 	"websocket_manager/internal/session"
@@ -13,7 +22,8 @@ import (
 )
 
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	cfg := config.Load("config/config.yaml")
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	storage, err := postgres.NewStorage(cfg.DatabaseUrl, logger.With("component", "storage"))
@@ -22,17 +32,88 @@ func main() {
 	}
 	logger.Debug("storage connected")
 	defer storage.Close()
+
+	var b broker.Broker
+	var router broker.Router
+	switch cfg.Broker {
+	case "memory":
+		memory := broker.NewMemory(broker.NewMemoryBus(), cfg.NodeID)
+		b, router = memory, memory
+	case "postgres":
+		pg, err := broker.NewPostgres(cfg.DatabaseUrl, cfg.NodeID, logger.With("component", "broker"))
+		if err != nil {
+			panic("failed to init broker")
+		}
+		defer pg.Close()
+		b, router = pg, pg
+	}
+	logger.Debug("broker connected", "broker", cfg.Broker, "node", cfg.NodeID)
+
 	logger.Debug("starting websocket server")
-	hub := server.NewHub(ctx, storage, logger.With("component", "hub"))
+	hub := server.NewHub(context.Background(), storage, b, router, cfg.MaxClients, logger.With("component", "hub"))
+	expvar.Publish("ws_send_queue", expvar.Func(hub.SendQueueStats))
+	go hub.Run()
+
+	keys := jwt.NewJWKSCache(cfg.JWKSUrl, cfg.JWKSRefreshInterval)
+	if err := keys.Refresh(ctx); err != nil {
+		// auth_service may still be starting, keys are fetched again on the first connection
+		logger.Warn("failed to fetch jwks", "error", err)
+	}
+
+	var limitStore ratelimit.Store = ratelimit.NewMemory()
+	if cfg.RateLimitStore == "postgres" {
+		pg, err := ratelimit.NewBatched(cfg.DatabaseUrl, logger.With("component", "ratelimit"))
+		if err != nil {
+			panic("failed to init rate limit store")
+		}
+		defer pg.Close()
+		go pg.Run(ctx, cfg.RateLimitSyncInterval)
+		limitStore = pg
+	}
 
+	options := session.Options{
+		MaxMessageSize: cfg.MaxMessageSize,
+		SendQueueSize:  cfg.SendQueueSize,
+		QueuePolicy:    session.QueuePolicy(cfg.SlowConsumerPolicy),
+		RateLimits: &session.RateLimits{
+			Session: ratelimit.Limit{Rate: cfg.SessionRate, Burst: cfg.SessionBurst},
+			Default: ratelimit.Limit{Rate: cfg.PacketRate, Burst: cfg.PacketBurst},
+			Types:   session.DefaultTypeLimits,
+			Local:   ratelimit.NewMemory(),
+			Store:   limitStore,
+		},
+	}
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		session.ServeWs(hub, w, r)
+		session.ServeWs(hub, keys, options, w, r)
 	})
-	addr := fmt.Sprintf("%s:%v", cfg.Hostname, cfg.Port)
-	logger.Info("websocket server started", "address", addr)
-	err = http.ListenAndServe(addr, nil)
 
+	blobs, err := blob.NewFilesystem(cfg.AttachmentsDir)
 	if err != nil {
-		logger.Error("failed to start server", "error", err)
+		panic("failed to init blob store")
+	}
+	go attachments.RunSweeper(ctx, logger.With("component", "attachment_sweeper"), storage, blobs, cfg.AttachmentSweepInterval, cfg.UnlinkedAttachmentTTL)
+	limits := attachments.Limits{MaxSize: cfg.MaxAttachmentSize, AllowedTypes: cfg.AllowedAttachmentTypes}
+	http.Handle("POST /attachments", attachments.Upload(logger.With("handler", "upload_attachment"), storage, blobs, keys, limits))
+	http.Handle("GET /attachments/{id}", attachments.Download(logger.With("handler", "download_attachment"), storage, blobs, keys))
+	addr := fmt.Sprintf("%s:%v", cfg.Hostname, cfg.Port)
+	srv := &http.Server{Addr: addr}
+	go func() {
+		logger.Info("websocket server started", "address", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("failed to start server", "error", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	// stop taking new sockets first, hijacked websocket connections are drained by the hub
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to shut down server", "error", err)
+	}
+	if err := hub.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to drain hub", "error", err)
 	}
 }
@@ -0,0 +1,61 @@
+// Package attachments serves uploads and downloads of message attachments over HTTP.
+// Both need the same bearer token as the websocket.
+package attachments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"messenger-shared/jwt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Limits restrict what can be uploaded, the content type is sniffed from the file
+// itself and must be one of AllowedTypes.
+type Limits struct {
+	MaxSize      int64
+	AllowedTypes []string
+}
+
+const (
+	// sniffLen is how much of the file http.DetectContentType looks at.
+	sniffLen = 512
+	// multipartOverhead is the room left for multipart headers above MaxSize.
+	multipartOverhead = 64 << 10
+	maxFilenameLength = 255
+)
+
+func authenticate(logger *slog.Logger, keys *jwt.JWKSCache, w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	tokenStr, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		logger.Error("no authorization header")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+	id, err := jwt.ParseToken(keys, tokenStr)
+	if err != nil {
+		logger.Error("failed to parse token", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+	return id, true
+}
+
+func newStorageKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		return "attachment"
+	}
+	if len(name) > maxFilenameLength {
+		name = name[len(name)-maxFilenameLength:]
+	}
+	return name
+}
@@ -0,0 +1,87 @@
+package attachments
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"messenger-shared/jwt"
+	"mime"
+	"net/http"
+	"strconv"
+	"websocket_manager/internal/blob"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+// Download streams an attachment to members of the chat it was sent to, or to the
+// uploader while it isn't sent yet. Everyone else gets 404, not 403, so ids can't be
+// probed.
+func Download(logger *slog.Logger, store storage.Storage, blobs blob.Store, keys *jwt.JWKSCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticate(logger, keys, w, r)
+		if !ok {
+			return
+		}
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid attachment id", http.StatusBadRequest)
+			return
+		}
+		attachment, err := allowedAttachment(store, id, userID)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to get attachment", "error", err, "id", id)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		file, err := blobs.Open(r.Context(), attachment.StorageKey)
+		if errors.Is(err, blob.ErrNotFound) {
+			logger.Error("attachment without blob", "id", id)
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to open blob", "error", err, "id", id)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+		w.Header().Set("Content-Type", attachment.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := io.Copy(w, file); err != nil {
+			logger.Error("failed to write attachment", "error", err, "id", id)
+		}
+	}
+}
+
+func allowedAttachment(store storage.Storage, id uint64, userID uint64) (*model.Attachment, error) {
+	uow, err := store.CreateUnitOfWork()
+	if err != nil {
+		return nil, err
+	}
+	defer uow.Rollback()
+	attachment, chatID, err := uow.AttachmentRepository().GetAttachment(id)
+	if err != nil {
+		return nil, err
+	}
+	if chatID == 0 {
+		if attachment.UserID != userID {
+			return nil, storage.ErrNotFound
+		}
+		return attachment, nil
+	}
+	member, err := uow.ChatRepository().IsMember(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, storage.ErrNotFound
+	}
+	return attachment, nil
+}
@@ -0,0 +1,97 @@
+package attachments
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"websocket_manager/internal/blob"
+	"websocket_manager/internal/storage"
+)
+
+// sweepBatch is how many blobs one pass of the sweeper removes at most per transaction.
+const sweepBatch = 100
+
+// RunSweeper deletes uploads never sent with a message within unlinkedTTL, then the
+// blobs of every deleted attachment, every interval until ctx is done. Attachments
+// deleted with their message, chat or uploader are only queued by the database, the
+// sweeper is what frees their files.
+func RunSweeper(ctx context.Context, logger *slog.Logger, storage storage.Storage, blobs blob.Store, interval time.Duration, unlinkedTTL time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := deleteUnlinked(storage, unlinkedTTL, logger); err != nil {
+				logger.Error("failed to delete unlinked attachments", "error", err)
+			}
+			if err := deleteBlobs(ctx, storage, blobs, logger); err != nil {
+				logger.Error("failed to delete blobs", "error", err)
+			}
+		}
+	}
+}
+
+func deleteUnlinked(storage storage.Storage, ttl time.Duration, logger *slog.Logger) error {
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		return err
+	}
+	defer uow.Rollback()
+	deleted, err := uow.AttachmentRepository().DeleteUnlinked(time.Now().Add(-ttl))
+	if err != nil {
+		return err
+	}
+	if err := uow.Commit(); err != nil {
+		return err
+	}
+	if deleted > 0 {
+		logger.Info("unlinked attachments deleted", "count", deleted)
+	}
+	return nil
+}
+
+// deleteBlobs removes the queued blobs batch by batch. A blob that fails to delete
+// stays queued and stops the pass, the next one retries it.
+func deleteBlobs(ctx context.Context, storage storage.Storage, blobs blob.Store, logger *slog.Logger) error {
+	for {
+		done, err := deleteBlobBatch(ctx, storage, blobs, logger)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+func deleteBlobBatch(ctx context.Context, storage storage.Storage, blobs blob.Store, logger *slog.Logger) (bool, error) {
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		return false, err
+	}
+	defer uow.Rollback()
+	keys, err := uow.AttachmentRepository().GetDeletedBlobs(sweepBatch)
+	if err != nil || len(keys) == 0 {
+		return true, err
+	}
+	removed := make([]string, 0, len(keys))
+	var deleteErr error
+	for _, key := range keys {
+		if deleteErr = blobs.Delete(ctx, key); deleteErr != nil {
+			break
+		}
+		removed = append(removed, key)
+	}
+	if len(removed) > 0 {
+		if err := uow.AttachmentRepository().ForgetDeletedBlobs(removed); err != nil {
+			return false, err
+		}
+		if err := uow.Commit(); err != nil {
+			return false, err
+		}
+		logger.Debug("blobs deleted", "count", len(removed))
+	}
+	if deleteErr != nil {
+		return false, deleteErr
+	}
+	return len(keys) < sweepBatch, nil
+}
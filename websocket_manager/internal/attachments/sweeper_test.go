@@ -0,0 +1,79 @@
+package attachments
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+	"websocket_manager/internal/blob"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage/memory"
+)
+
+func TestSweeperDeletesBlobs(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	st := memory.NewStorage()
+	blobs, err := blob.NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"sent", "deleted", "stale", "fresh"} {
+		if _, err := blobs.Put(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uow, _ := st.CreateUnitOfWork()
+	chat := &model.Chat{Name: "chat", CreatorID: 1}
+	uow.ChatRepository().CreateChat(chat)
+	uow.ChatRepository().AddUserToChat(&model.ChatUsers{ChatID: chat.ID, UserID: 1, Role: model.Owner})
+	kept := &model.Message{ChatID: chat.ID, UserID: 1, Message: "kept"}
+	gone := &model.Message{ChatID: chat.ID, UserID: 1, Message: "gone"}
+	uow.MessageRepository().AddMessage(kept)
+	uow.MessageRepository().AddMessage(gone)
+	ids := make(map[string]uint64)
+	for _, key := range []string{"sent", "deleted", "stale"} {
+		attachment := &model.Attachment{UserID: 1, StorageKey: key, Filename: key, ContentType: "text/plain", Size: int64(len(key))}
+		uow.AttachmentRepository().CreateAttachment(attachment)
+		ids[key] = attachment.ID
+	}
+	uow.AttachmentRepository().AttachToMessage([]uint64{ids["sent"]}, 1, kept.ID)
+	uow.AttachmentRepository().AttachToMessage([]uint64{ids["deleted"]}, 1, gone.ID)
+	if err := uow.MessageRepository().DeleteMessage(gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := uow.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	uow, _ = st.CreateUnitOfWork()
+	fresh := &model.Attachment{UserID: 1, StorageKey: "fresh", Filename: "fresh", ContentType: "text/plain", Size: 5}
+	uow.AttachmentRepository().CreateAttachment(fresh)
+	uow.Commit()
+	if err := deleteUnlinked(st, 10*time.Millisecond, logger); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteBlobs(ctx, st, blobs, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{"sent": true, "deleted": false, "stale": false, "fresh": true} {
+		r, err := blobs.Open(ctx, key)
+		if err == nil {
+			r.Close()
+		}
+		if exists := !errors.Is(err, blob.ErrNotFound); exists != want {
+			t.Errorf("blob %s exists %v, want %v", key, exists, want)
+		}
+	}
+	uow, _ = st.CreateUnitOfWork()
+	defer uow.Rollback()
+	if keys, _ := uow.AttachmentRepository().GetDeletedBlobs(sweepBatch); len(keys) != 0 {
+		t.Errorf("blobs %v still queued", keys)
+	}
+}
@@ -0,0 +1,107 @@
+package attachments
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"messenger-shared/jwt"
+	"mime"
+	"net/http"
+	"slices"
+	"websocket_manager/internal/blob"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+// Upload stores the "file" field of a multipart form and answers with the attachment,
+// its id goes into the attachments of a SendMessage packet.
+func Upload(logger *slog.Logger, storage storage.Storage, blobs blob.Store, keys *jwt.JWKSCache, limits Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticate(logger, keys, w, r)
+		if !ok {
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limits.MaxSize+multipartOverhead)
+		reader, err := r.MultipartReader()
+		if err != nil {
+			logger.Error("failed to read multipart form", "error", err)
+			http.Error(w, "Multipart form expected", http.StatusBadRequest)
+			return
+		}
+		part, err := reader.NextPart()
+		for err == nil && part.FormName() != "file" {
+			part.Close()
+			part, err = reader.NextPart()
+		}
+		if err != nil {
+			logger.Error("failed to find file field", "error", err)
+			http.Error(w, "File field is required", http.StatusBadRequest)
+			return
+		}
+		defer part.Close()
+
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(part, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			logger.Error("failed to read file", "error", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if n == 0 {
+			http.Error(w, "File is empty", http.StatusBadRequest)
+			return
+		}
+		head = head[:n]
+		contentType := http.DetectContentType(head)
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if !slices.Contains(limits.AllowedTypes, mediaType) {
+			logger.Warn("content type not allowed", "content_type", contentType, "user_id", userID)
+			http.Error(w, "Content type not allowed", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		key := newStorageKey()
+		// one byte over the limit is enough to know the file is too big
+		size, err := blobs.Put(r.Context(), key, io.MultiReader(bytes.NewReader(head), io.LimitReader(part, limits.MaxSize+1-int64(n))))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || (err == nil && size > limits.MaxSize) {
+			blobs.Delete(r.Context(), key)
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to store file", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		attachment := &model.Attachment{UserID: userID, StorageKey: key, Filename: cleanFilename(part.FileName()), ContentType: contentType, Size: size}
+		if err := saveAttachment(storage, attachment); err != nil {
+			logger.Error("failed to save attachment", "error", err)
+			blobs.Delete(r.Context(), key)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(attachment); err != nil {
+			logger.Error("failed to write attachment", "error", err)
+			return
+		}
+		logger.Info("attachment uploaded", "id", attachment.ID, "user_id", userID, "size", size, "content_type", contentType)
+	}
+}
+
+func saveAttachment(storage storage.Storage, attachment *model.Attachment) error {
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		return err
+	}
+	defer uow.Rollback()
+	if err := uow.AttachmentRepository().CreateAttachment(attachment); err != nil {
+		return err
+	}
+	return uow.Commit()
+}
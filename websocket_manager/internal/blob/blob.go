@@ -0,0 +1,18 @@
+// Package blob stores attachment contents. Blobs are addressed by an opaque key, so a
+// filesystem, an S3-compatible bucket or anything else with put/get/delete fits.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type Store interface {
+	// Put writes the whole reader under the key and returns the number of bytes written.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,33 @@
+package broker
+
+import (
+	"context"
+	"websocket_manager/internal/model"
+)
+
+// Envelope carries a packet from the node that produced it to a node where some of
+// its recipients are connected.
+type Envelope struct {
+	UserIDs []uint64                    `json:"user_ids"`
+	Packet  *model.MessagePacketRequest `json:"packet"`
+}
+
+// Broker moves envelopes between websocket_manager nodes.
+type Broker interface {
+	// Node is the id of this node, envelopes published to it reach its subscriber.
+	Node() string
+	Publish(ctx context.Context, node string, envelope *Envelope) error
+	// Subscribe calls deliver for every envelope published to this node until ctx is done.
+	Subscribe(ctx context.Context, deliver func(*Envelope)) error
+}
+
+// Router is the node-to-user routing table: which nodes hold sessions of which users.
+type Router interface {
+	// Connect records that this node holds a session of the user.
+	Connect(ctx context.Context, userID uint64) error
+	// Disconnect records that this node holds no session of the user anymore.
+	Disconnect(ctx context.Context, userID uint64) error
+	// Routes groups the users by the nodes they are connected to, users connected
+	// nowhere are left out.
+	Routes(ctx context.Context, userIDs []uint64) (map[string][]uint64, error)
+}
@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBus connects in-process nodes, it is enough for a single replica and for tests.
+type MemoryBus struct {
+	mu          sync.Mutex
+	subscribers map[string]func(*Envelope)
+	routes      map[uint64]map[string]struct{}
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		subscribers: make(map[string]func(*Envelope)),
+		routes:      make(map[uint64]map[string]struct{}),
+	}
+}
+
+// Memory is one node on a MemoryBus, it implements both Broker and Router.
+type Memory struct {
+	bus  *MemoryBus
+	node string
+}
+
+func NewMemory(bus *MemoryBus, node string) *Memory {
+	return &Memory{bus: bus, node: node}
+}
+
+func (m *Memory) Node() string {
+	return m.node
+}
+
+func (m *Memory) Publish(ctx context.Context, node string, envelope *Envelope) error {
+	m.bus.mu.Lock()
+	deliver, ok := m.bus.subscribers[node]
+	m.bus.mu.Unlock()
+	if ok {
+		deliver(envelope)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, deliver func(*Envelope)) error {
+	m.bus.mu.Lock()
+	m.bus.subscribers[m.node] = deliver
+	m.bus.mu.Unlock()
+
+	<-ctx.Done()
+
+	m.bus.mu.Lock()
+	delete(m.bus.subscribers, m.node)
+	m.bus.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) Connect(ctx context.Context, userID uint64) error {
+	m.bus.mu.Lock()
+	defer m.bus.mu.Unlock()
+	nodes, ok := m.bus.routes[userID]
+	if !ok {
+		nodes = make(map[string]struct{})
+		m.bus.routes[userID] = nodes
+	}
+	nodes[m.node] = struct{}{}
+	return nil
+}
+
+func (m *Memory) Disconnect(ctx context.Context, userID uint64) error {
+	m.bus.mu.Lock()
+	defer m.bus.mu.Unlock()
+	delete(m.bus.routes[userID], m.node)
+	if len(m.bus.routes[userID]) == 0 {
+		delete(m.bus.routes, userID)
+	}
+	return nil
+}
+
+func (m *Memory) Routes(ctx context.Context, userIDs []uint64) (map[string][]uint64, error) {
+	m.bus.mu.Lock()
+	defer m.bus.mu.Unlock()
+	routes := make(map[string][]uint64)
+	for _, u := range userIDs {
+		for node := range m.bus.routes[u] {
+			routes[node] = append(routes[node], u)
+		}
+	}
+	return routes, nil
+}
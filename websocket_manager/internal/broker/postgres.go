@@ -0,0 +1,318 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	heartbeatInterval = 10 * time.Second
+	// nodeTimeout is how long a node without heartbeat keeps its routes.
+	nodeTimeout = 30 * time.Second
+	// maxNotifyPayload stays below the 8000 bytes NOTIFY accepts, bigger envelopes go
+	// through ws_payloads and only their id is notified.
+	maxNotifyPayload = 7900
+	payloadTTL       = time.Minute
+	reconnectDelay   = time.Second
+	// nodesChannel is notified with the id of every node that joins.
+	nodesChannel = "ws_nodes"
+)
+
+// Postgres is a Broker and Router on top of LISTEN/NOTIFY, every node listens on its
+// own channel and the routing table lives in ws_routes.
+type Postgres struct {
+	db          *pgxpool.Pool
+	databaseUrl string
+	node        string
+	logger      *slog.Logger
+
+	// local holds the users connected to this node, so their routes can be restored
+	// after other nodes dropped the node for missing heartbeats. writes orders the
+	// route writes, mu guards local only so Routes never waits on the database.
+	writes sync.Mutex
+	mu     sync.Mutex
+	local  map[uint64]struct{}
+	// peers is the number of other live nodes, Routes answers from local without a
+	// query while there are none.
+	peers atomic.Int64
+}
+
+func NewPostgres(databaseUrl string, node string, logger *slog.Logger) (*Postgres, error) {
+	db, err := pgxpool.New(context.Background(), databaseUrl)
+	if err != nil {
+		logger.Error("failed connect to database", "error", err)
+		return nil, err
+	}
+	p := &Postgres{db: db, databaseUrl: databaseUrl, node: node, logger: logger, local: make(map[uint64]struct{})}
+	// routes left from a previous run of the same node are stale
+	if _, err := db.Exec(context.Background(), "DELETE FROM ws_nodes WHERE node_id = $1", node); err != nil {
+		logger.Error("failed to clear node", "error", err)
+		db.Close()
+		return nil, err
+	}
+	if err := p.beat(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// the other nodes start looking up routes now that they have a peer
+	if _, err := db.Exec(context.Background(), "SELECT pg_notify($1, $2)", nodesChannel, node); err != nil {
+		logger.Error("failed to announce node", "error", err)
+		db.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Postgres) Node() string {
+	return p.node
+}
+
+func (p *Postgres) channel(node string) string {
+	return "ws_node_" + node
+}
+
+func (p *Postgres) Publish(ctx context.Context, node string, envelope *Envelope) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		p.logger.Error("failed to marshal envelope", "error", err)
+		return err
+	}
+	notification := string(payload)
+	if len(payload) > maxNotifyPayload {
+		var id uint64
+		err := p.db.QueryRow(ctx, "INSERT INTO ws_payloads (payload) VALUES ($1) RETURNING id", notification).Scan(&id)
+		if err != nil {
+			p.logger.Error("failed to store payload", "error", err)
+			return err
+		}
+		notification = "#" + strconv.FormatUint(id, 10)
+	}
+	if _, err := p.db.Exec(ctx, "SELECT pg_notify($1, $2)", p.channel(node), notification); err != nil {
+		p.logger.Error("failed to notify", "error", err, "node", node)
+		return err
+	}
+	return nil
+}
+
+// Subscribe listens on the channel of the node and keeps its heartbeat going. A lost
+// connection is reopened, envelopes published in between are lost.
+func (p *Postgres) Subscribe(ctx context.Context, deliver func(*Envelope)) error {
+	go p.heartbeat(ctx)
+	for {
+		err := p.listen(ctx, deliver)
+		if ctx.Err() != nil {
+			return nil
+		}
+		p.logger.Error("lost listen connection", "error", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (p *Postgres) listen(ctx context.Context, deliver func(*Envelope)) error {
+	conn, err := pgx.Connect(ctx, p.databaseUrl)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	for _, channel := range []string{p.channel(p.node), nodesChannel} {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return err
+		}
+	}
+	// nodes may have joined while the connection was down
+	if err := p.refreshPeers(ctx); err != nil {
+		return err
+	}
+	p.logger.Info("listening for envelopes", "node", p.node)
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if notification.Channel == nodesChannel {
+			if notification.Payload != p.node {
+				_ = p.refreshPeers(ctx)
+			}
+			continue
+		}
+		payload := notification.Payload
+		if id, ok := strings.CutPrefix(payload, "#"); ok {
+			err := p.db.QueryRow(ctx, "DELETE FROM ws_payloads WHERE id = $1 RETURNING payload", id).Scan(&payload)
+			if err != nil {
+				p.logger.Error("failed to load payload", "error", err, "id", id)
+				continue
+			}
+		}
+		var envelope Envelope
+		if err := json.Unmarshal([]byte(payload), &envelope); err != nil {
+			p.logger.Error("failed to unmarshal envelope", "error", err)
+			continue
+		}
+		deliver(&envelope)
+	}
+}
+
+func (p *Postgres) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = p.beat(ctx)
+		}
+	}
+}
+
+// beat marks the node alive and drops the routes of nodes that stopped beating. A node
+// late on its heartbeats may have been dropped that way too, its routes are restored
+// when beat has to insert it again.
+func (p *Postgres) beat(ctx context.Context) error {
+	var inserted bool
+	err := p.db.QueryRow(ctx, `INSERT INTO ws_nodes (node_id) VALUES ($1)
+		ON CONFLICT (node_id) DO UPDATE SET heartbeat_at = now() RETURNING xmax = 0`, p.node).Scan(&inserted)
+	if err != nil {
+		p.logger.Error("failed to update heartbeat", "error", err)
+		return err
+	}
+	if inserted {
+		if err := p.restoreRoutes(ctx); err != nil {
+			return err
+		}
+	}
+	if _, err := p.db.Exec(ctx, "DELETE FROM ws_nodes WHERE heartbeat_at < now() - $1::interval", nodeTimeout); err != nil {
+		p.logger.Error("failed to drop dead nodes", "error", err)
+		return err
+	}
+	if _, err := p.db.Exec(ctx, "DELETE FROM ws_payloads WHERE created_at < now() - $1::interval", payloadTTL); err != nil {
+		p.logger.Error("failed to drop old payloads", "error", err)
+		return err
+	}
+	return p.refreshPeers(ctx)
+}
+
+// restoreRoutes adds the routes of every user connected to this node.
+func (p *Postgres) restoreRoutes(ctx context.Context) error {
+	p.writes.Lock()
+	defer p.writes.Unlock()
+	p.mu.Lock()
+	users := slices.Collect(maps.Keys(p.local))
+	p.mu.Unlock()
+	if len(users) == 0 {
+		return nil
+	}
+	_, err := p.db.Exec(ctx, "INSERT INTO ws_routes (node_id, user_id) SELECT $1, unnest($2::bigint[]) ON CONFLICT DO NOTHING", p.node, users)
+	if err != nil {
+		p.logger.Error("failed to restore routes", "error", err)
+		return err
+	}
+	p.logger.Warn("node was dropped, routes restored", "users", len(users))
+	return nil
+}
+
+func (p *Postgres) refreshPeers(ctx context.Context) error {
+	var peers int64
+	err := p.db.QueryRow(ctx, "SELECT count(*) FROM ws_nodes WHERE node_id <> $1 AND heartbeat_at > now() - $2::interval", p.node, nodeTimeout).Scan(&peers)
+	if err != nil {
+		p.logger.Error("failed to count nodes", "error", err)
+		return err
+	}
+	p.peers.Store(peers)
+	return nil
+}
+
+// Connect records the user locally first, a route that fails to insert because the
+// node was dropped comes back with the next heartbeat.
+func (p *Postgres) Connect(ctx context.Context, userID uint64) error {
+	p.writes.Lock()
+	defer p.writes.Unlock()
+	p.mu.Lock()
+	p.local[userID] = struct{}{}
+	p.mu.Unlock()
+	_, err := p.db.Exec(ctx, "INSERT INTO ws_routes (node_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", p.node, userID)
+	if err != nil {
+		p.logger.Error("failed to add route", "error", err, "user_id", userID)
+		return err
+	}
+	return nil
+}
+
+func (p *Postgres) Disconnect(ctx context.Context, userID uint64) error {
+	p.writes.Lock()
+	defer p.writes.Unlock()
+	p.mu.Lock()
+	delete(p.local, userID)
+	p.mu.Unlock()
+	_, err := p.db.Exec(ctx, "DELETE FROM ws_routes WHERE node_id = $1 AND user_id = $2", p.node, userID)
+	if err != nil {
+		p.logger.Error("failed to remove route", "error", err, "user_id", userID)
+		return err
+	}
+	return nil
+}
+
+func (p *Postgres) Routes(ctx context.Context, userIDs []uint64) (map[string][]uint64, error) {
+	if p.peers.Load() == 0 {
+		return p.localRoutes(userIDs), nil
+	}
+	rows, err := p.db.Query(ctx, `SELECT r.node_id, r.user_id FROM ws_routes r JOIN ws_nodes n ON n.node_id = r.node_id
+		WHERE r.user_id = ANY($1) AND n.heartbeat_at > now() - $2::interval`, userIDs, nodeTimeout)
+	if err != nil {
+		p.logger.Error("failed to get routes", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := make(map[string][]uint64)
+	for rows.Next() {
+		var node string
+		var userID uint64
+		if err := rows.Scan(&node, &userID); err != nil {
+			p.logger.Error("failed to scan route", "error", err)
+			return nil, err
+		}
+		routes[node] = append(routes[node], userID)
+	}
+	if err := rows.Err(); err != nil {
+		p.logger.Error("failed to read routes", "error", err)
+		return nil, err
+	}
+	return routes, nil
+}
+
+// localRoutes routes the users connected to this node, for a node without peers.
+func (p *Postgres) localRoutes(userIDs []uint64) map[string][]uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	routes := make(map[string][]uint64)
+	for _, u := range userIDs {
+		if _, ok := p.local[u]; ok {
+			routes[p.node] = append(routes[p.node], u)
+		}
+	}
+	return routes
+}
+
+// Close removes the node with its routes and closes the pool.
+func (p *Postgres) Close() {
+	if _, err := p.db.Exec(context.Background(), "DELETE FROM ws_nodes WHERE node_id = $1", p.node); err != nil {
+		p.logger.Error("failed to remove node", "error", err)
+	}
+	p.db.Close()
+}
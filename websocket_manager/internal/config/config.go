@@ -3,15 +3,49 @@ package config
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	Hostname      string `yaml:"hostname" required:"true" env-default:"localhost"`
-	Port          uint16 `yaml:"port" default:"52525"`
-	DatabaseUrl   string `yaml:"database_url" env:"DATABASE_URL" env-required:"true"`
-	PublicKeyPath string `yaml:"public_key_path" env:"PUBLIC_KEY_PATH" env-required:"true"`
+	Hostname    string `yaml:"hostname" required:"true" env-default:"localhost"`
+	Port        uint16 `yaml:"port" default:"52525"`
+	DatabaseUrl string `yaml:"database_url" env:"DATABASE_URL" env-required:"true"`
+	// JWKSUrl is where auth_service publishes the keys tokens are verified with.
+	JWKSUrl             string        `yaml:"jwks_url" env:"JWKS_URL" env-default:"http://auth:52521/.well-known/jwks.json"`
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval" env:"JWKS_REFRESH_INTERVAL" env-default:"5m"`
+	// Broker is how replicas reach each other: postgres, or memory for a single replica.
+	Broker string `yaml:"broker" env:"BROKER" env-default:"postgres"`
+	// NodeID names this replica in the routing table, the hostname when empty.
+	NodeID string `yaml:"node_id" env:"NODE_ID"`
+	// MaxClients is the number of sessions this replica accepts.
+	MaxClients     int   `yaml:"max_clients" env:"MAX_CLIENTS" env-default:"52"`
+	MaxMessageSize int64 `yaml:"max_message_size" env:"MAX_MESSAGE_SIZE" env-default:"1024"`
+	// SendQueueSize is how many outgoing packets a session buffers, SlowConsumerPolicy
+	// what happens when it is full: drop_oldest or disconnect.
+	SendQueueSize      int    `yaml:"send_queue_size" env:"SEND_QUEUE_SIZE" env-default:"256"`
+	SlowConsumerPolicy string `yaml:"slow_consumer_policy" env:"SLOW_CONSUMER_POLICY" env-default:"disconnect"`
+	// AttachmentsDir is where the filesystem blob store keeps uploaded files.
+	AttachmentsDir         string   `yaml:"attachments_dir" env:"ATTACHMENTS_DIR" env-default:"attachments"`
+	MaxAttachmentSize      int64    `yaml:"max_attachment_size" env:"MAX_ATTACHMENT_SIZE" env-default:"10485760"`
+	AllowedAttachmentTypes []string `yaml:"allowed_attachment_types" env:"ALLOWED_ATTACHMENT_TYPES" env-separator:"," env-default:"image/png,image/jpeg,image/gif,image/webp,text/plain,application/pdf,application/zip"`
+	// UnlinkedAttachmentTTL is how long an upload waits to be sent with a message before
+	// the sweeper deletes it, the sweeper also frees the files of deleted attachments.
+	UnlinkedAttachmentTTL   time.Duration `yaml:"unlinked_attachment_ttl" env:"UNLINKED_ATTACHMENT_TTL" env-default:"24h"`
+	AttachmentSweepInterval time.Duration `yaml:"attachment_sweep_interval" env:"ATTACHMENT_SWEEP_INTERVAL" env-default:"10m"`
+	// RateLimitStore keeps the per user packet limits: memory, or postgres to share them
+	// between replicas, synced every RateLimitSyncInterval. SessionRate limits all packets
+	// of one connection in memory, PacketRate the packets of one user per MsgType without
+	// a stricter built-in limit, in packets per second.
+	RateLimitStore        string        `yaml:"rate_limit_store" env:"RATE_LIMIT_STORE" env-default:"memory"`
+	RateLimitSyncInterval time.Duration `yaml:"rate_limit_sync_interval" env:"RATE_LIMIT_SYNC_INTERVAL" env-default:"1s"`
+	SessionRate           float64       `yaml:"session_rate" env:"SESSION_RATE" env-default:"20"`
+	SessionBurst          int           `yaml:"session_burst" env:"SESSION_BURST" env-default:"40"`
+	PacketRate            float64       `yaml:"packet_rate" env:"PACKET_RATE" env-default:"10"`
+	PacketBurst           int           `yaml:"packet_burst" env:"PACKET_BURST" env-default:"30"`
+	// ShutdownTimeout bounds how long sessions and in-flight packets are drained on SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" env-default:"20s"`
 }
 
 func Load(configPath string) *Config {
@@ -25,10 +59,30 @@ func Load(configPath string) *Config {
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
 		log.Fatalf("failed to read config: %v", err)
 	}
-	publicKey, err := os.ReadFile(cfg.PublicKeyPath)
-	if err != nil {
-		log.Fatalf("failed to read public key: %v", err)
+	if cfg.Broker != "postgres" && cfg.Broker != "memory" {
+		log.Fatalf("broker must be one of postgres, memory: %q", cfg.Broker)
+	}
+	if cfg.MaxClients <= 0 || cfg.MaxMessageSize <= 0 || cfg.SendQueueSize <= 0 || cfg.MaxAttachmentSize <= 0 {
+		log.Fatalf("max_clients, max_message_size, send_queue_size and max_attachment_size must be positive")
+	}
+	if cfg.UnlinkedAttachmentTTL <= 0 || cfg.AttachmentSweepInterval <= 0 {
+		log.Fatalf("unlinked_attachment_ttl and attachment_sweep_interval must be positive")
+	}
+	if cfg.RateLimitSyncInterval <= 0 {
+		log.Fatalf("rate_limit_sync_interval must be positive")
+	}
+	if cfg.SlowConsumerPolicy != "drop_oldest" && cfg.SlowConsumerPolicy != "disconnect" {
+		log.Fatalf("slow_consumer_policy must be one of drop_oldest, disconnect: %q", cfg.SlowConsumerPolicy)
+	}
+	if cfg.RateLimitStore != "postgres" && cfg.RateLimitStore != "memory" {
+		log.Fatalf("rate_limit_store must be one of postgres, memory: %q", cfg.RateLimitStore)
+	}
+	if cfg.NodeID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("failed to get hostname: %v", err)
+		}
+		cfg.NodeID = hostname
 	}
-	os.Setenv("AUTH_SERVICE_PUBLIC_KEY", string(publicKey))
 	return &cfg
 }
@@ -0,0 +1,17 @@
+// Package metrics holds the counters websocket_manager publishes through expvar on
+// /debug/vars.
+package metrics
+
+import "expvar"
+
+var (
+	Sessions = expvar.NewInt("ws_sessions")
+	// DroppedPackets counts packets thrown away from full send queues.
+	DroppedPackets = expvar.NewInt("ws_dropped_packets")
+	// SlowConsumerDisconnects counts sessions closed because their send queue was full.
+	SlowConsumerDisconnects = expvar.NewInt("ws_slow_consumer_disconnects")
+	// RejectedSessions counts connections refused because the node was full.
+	RejectedSessions = expvar.NewInt("ws_rejected_sessions")
+	// RateLimitedPackets counts packets refused because a rate limit was exceeded.
+	RateLimitedPackets = expvar.NewInt("ws_rate_limited_packets")
+)
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// Attachment is a file uploaded by a user. It belongs to nobody but the uploader until
+// a sent message references it, then every member of the chat can download it.
+type Attachment struct {
+	ID          uint64    `json:"id"`
+	UserID      uint64    `json:"user_id"`
+	MessageID   *uint64   `json:"message_id,omitempty"`
+	StorageKey  string    `json:"-"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
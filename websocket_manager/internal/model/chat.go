@@ -2,15 +2,63 @@ package model
 
 import "time"
 
+type ChatType string
+
+const (
+	Group ChatType = "group"
+	// Direct chats have exactly two members, both with the member role. They can't be
+	// renamed, deleted or change members.
+	Direct ChatType = "direct"
+)
+
 type Chat struct {
 	ID        uint64    `json:"id"`
 	Name      string    `json:"name"`
+	Type      ChatType  `json:"type"`
 	CreatorID uint64    `json:"creator_id"`
 	CreatedAt time.Time `json:"created_at,omitempty"`
 	UpdatedAt time.Time `json:"updated_at,omitempty"`
 }
 
+type ChatRole string
+
+const (
+	Owner    ChatRole = "owner"
+	Admin    ChatRole = "admin"
+	Member   ChatRole = "member"
+	ReadOnly ChatRole = "read_only"
+)
+
+var chatRoleRanks = map[ChatRole]int{
+	ReadOnly: 1,
+	Member:   2,
+	Admin:    3,
+	Owner:    4,
+}
+
+func (r ChatRole) Valid() bool {
+	_, ok := chatRoleRanks[r]
+	return ok
+}
+
+// CanModerate reports whether the role may manage members, rename the chat and
+// delete messages of others.
+func (r ChatRole) CanModerate() bool {
+	return r == Owner || r == Admin
+}
+
+func (r ChatRole) CanPost() bool {
+	return chatRoleRanks[r] >= chatRoleRanks[Member]
+}
+
+// Outranks reports whether r is strictly higher than other, only a higher role
+// can remove a member or change its role.
+func (r ChatRole) Outranks(other ChatRole) bool {
+	return chatRoleRanks[r] > chatRoleRanks[other]
+}
+
 type ChatUsers struct {
 	ChatID uint64
 	UserID uint64
+	Role   ChatRole
 }
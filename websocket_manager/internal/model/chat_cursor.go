@@ -0,0 +1,11 @@
+package model
+
+// ChatCursor is how far a user got in a chat: the last message delivered to one of
+// the user's devices and the last message the user has read.
+type ChatCursor struct {
+	ChatID                 uint64 `json:"chat_id"`
+	LastReadMessageID      uint64 `json:"last_read_message_id"`
+	LastDeliveredMessageID uint64 `json:"last_delivered_message_id"`
+	LastMessageID          uint64 `json:"last_message_id"`
+	UnreadCount            uint64 `json:"unread_count"`
+}
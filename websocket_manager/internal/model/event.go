@@ -0,0 +1,44 @@
+package model
+
+// Events are pushed by the hub to every online member of the chat they happened in.
+// The packet of an event has From set to the user who caused it and To set to the chat id.
+
+type MessageEditedEvent struct {
+	ID      uint64 `json:"id"`
+	Message string `json:"message"`
+}
+
+type MessageDeletedEvent struct {
+	ID uint64 `json:"id"`
+}
+
+type ChatRenamedEvent struct {
+	Name string `json:"name"`
+}
+
+type MemberRemovedEvent struct {
+	UserID uint64 `json:"user_id"`
+}
+
+// MemberEvent is sent when a member joins the chat or gets another role, Role is the
+// role the member has now.
+type MemberEvent struct {
+	UserID uint64   `json:"user_id"`
+	Role   ChatRole `json:"role"`
+}
+
+type MessageReceiptEvent struct {
+	UserID uint64        `json:"user_id"`
+	Status ReceiptStatus `json:"status"`
+	IDs    []uint64      `json:"ids"`
+}
+
+type TypingEvent struct {
+	Typing bool `json:"typing"`
+}
+
+type ReactionEvent struct {
+	MessageID uint64 `json:"message_id"`
+	UserID    uint64 `json:"user_id"`
+	Emoji     string `json:"emoji"`
+}
@@ -3,12 +3,20 @@ package model
 import "time"
 
 type Message struct {
-	ID        uint64    `json:"id"`
-	ChatID    uint64    `json:"chat_id"`
-	UserID    uint64    `json:"user_id"`
-	Message   string    `json:"message"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID      uint64 `json:"id"`
+	ChatID  uint64 `json:"chat_id"`
+	UserID  uint64 `json:"user_id"`
+	Message string `json:"message"`
+	// ReplyToID is the message this one answers, ThreadRootID the first message of the
+	// thread it belongs to. Both are nil outside threads.
+	ReplyToID    *uint64 `json:"reply_to_id,omitempty"`
+	ThreadRootID *uint64 `json:"thread_root_id,omitempty"`
+	// ReplyCount is the number of messages in the thread started by this one.
+	ReplyCount  uint64          `json:"reply_count,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Attachments []Attachment    `json:"attachments,omitempty"`
+	Reactions   []ReactionCount `json:"reactions,omitempty"`
 }
 
 func MessageToByte(m *Message) []byte {
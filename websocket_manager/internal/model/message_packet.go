@@ -11,7 +11,7 @@ const (
 	SendMessage
 	UpdateMessage
 	DeleteMessage
-	GetAllMessagesInChat // should be limited to some reasonable amount
+	GetAllMessagesInChat // whole history, use GetMessagesPage for long chats
 	CreateChat
 	UpdateChat
 	DeleteChat
@@ -20,18 +20,80 @@ const (
 	GetAllUsersIDInChat
 	GetAllUserChats
 	GetChatInfo
+	GetMessagesPage
+	SetChatRole
+	TransferChatOwnership
+	MessageEdited
+	MessageDeleted
+	ChatRenamed
+	ChatDeleted
+	MemberRemoved
+	UnreadCounts
+	SyncSince
+	MarkChatRead
+	AckMessages
+	ReceiptUpdated
+	GetMessageReaders
+	Typing
+	PresenceChanged
+	SetPresence
+	GetPresence
+	GetThread
+	AddReaction
+	RemoveReaction
+	ReactionAdded
+	ReactionRemoved
+	SearchMessages
+	OpenDirectChat
+	MemberAdded
+	MemberRoleChanged
 )
 
 const (
-	Success       = `"Success"`
-	InternalError = `"InternalError"`
+	Success = `"Success"`
 )
 
+// ErrorCode is a machine-readable reason of a failed request.
+type ErrorCode string
+
+const (
+	ValidationFailed ErrorCode = "validation_failed"
+	Forbidden        ErrorCode = "forbidden"
+	NotFound         ErrorCode = "not_found"
+	Conflict         ErrorCode = "conflict"
+	Internal         ErrorCode = "internal"
+	RateLimited      ErrorCode = "rate_limited"
+)
+
+// Error is sent back instead of data when a request fails. Only internal and rate
+// limited errors are worth retrying, the others will fail again with the same request.
+type Error struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	Retryable bool      `json:"retryable"`
+	// RetryAfterMs is set on rate limited errors, the time until the request is allowed.
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
+}
+
 type MessagePacketRequest struct {
-	MsgType MsgType         `json:"msgType"`
-	From    uint64          `json:"from,omitempty"`
-	To      uint64          `json:"to,omitempty"`
-	Data    json.RawMessage `json:"data"`
+	MsgType MsgType `json:"msgType"`
+	// RequestID is set by the client and echoed back in the response to correlate them.
+	RequestID string          `json:"requestId,omitempty"`
+	From      uint64          `json:"from,omitempty"`
+	To        uint64          `json:"to,omitempty"`
+	Data      json.RawMessage `json:"data"`
+	Error     *Error          `json:"error,omitempty"`
+}
+
+// NewErrorPacket builds the failure response to req.
+func NewErrorPacket(msgType MsgType, req *MessagePacketRequest, code ErrorCode, message string) *MessagePacketRequest {
+	return &MessagePacketRequest{
+		MsgType:   msgType,
+		RequestID: req.RequestID,
+		From:      0,
+		To:        req.From,
+		Error:     &Error{Code: code, Message: message, Retryable: code == Internal || code == RateLimited},
+	}
 }
 
 func ByteToMessagePacketRequest(b []byte) (*MessagePacketRequest, error) {
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+type PresenceStatus string
+
+const (
+	Online  PresenceStatus = "online"
+	Away    PresenceStatus = "away"
+	Offline PresenceStatus = "offline"
+)
+
+// Presence is what users sharing a chat see about each other. LastSeenAt is set
+// for offline users who have been online at least once.
+type Presence struct {
+	UserID     uint64         `json:"user_id"`
+	Status     PresenceStatus `json:"status"`
+	LastSeenAt *time.Time     `json:"last_seen_at,omitempty"`
+}
@@ -0,0 +1,9 @@
+package model
+
+// ReactionCount is how many members reacted to a message with the emoji, Me tells
+// whether the user asking is one of them.
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count uint64 `json:"count"`
+	Me    bool   `json:"me,omitempty"`
+}
@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+type ReceiptStatus string
+
+const (
+	Delivered ReceiptStatus = "delivered"
+	Read      ReceiptStatus = "read"
+)
+
+func (s ReceiptStatus) Valid() bool {
+	return s == Delivered || s == Read
+}
+
+// MessageReceipt tells when a message reached one of the user's devices and when the
+// user read it, ReadAt is nil until then.
+type MessageReceipt struct {
+	MessageID   uint64     `json:"message_id"`
+	UserID      uint64     `json:"user_id"`
+	DeliveredAt time.Time  `json:"delivered_at"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
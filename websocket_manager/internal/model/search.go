@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// MessageSearch is a full-text query over the chats of a user. Zero filters are not
+// applied, results are newest first and BeforeID continues after the last page.
+type MessageSearch struct {
+	Query    string
+	ChatID   uint64
+	SenderID uint64
+	From     *time.Time
+	To       *time.Time
+	BeforeID uint64
+}
+
+// SearchResult is a matching message with a snippet of it, matches are wrapped in
+// <mark></mark> and the rest of the snippet is HTML-escaped.
+type SearchResult struct {
+	Message Message `json:"message"`
+	Snippet string  `json:"snippet"`
+}
@@ -1,6 +1,7 @@
 package model
 
 type User struct {
-	Id   uint64 `json:"id"`
-	Name string `json:"name"`
+	Id   uint64   `json:"id"`
+	Name string   `json:"name"`
+	Role ChatRole `json:"role,omitempty"`
 }
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+// chatScoped lists the message types whose To is a chat id. Only members of that
+// chat may send them.
+var chatScoped = map[model.MsgType]bool{
+	model.SendMessage:           true,
+	model.DeleteMessage:         true,
+	model.GetAllMessagesInChat:  true,
+	model.GetMessagesPage:       true,
+	model.UpdateChat:            true,
+	model.DeleteChat:            true,
+	model.AddUserToChat:         true,
+	model.DeleteUserFromChat:    true,
+	model.GetAllUsersIDInChat:   true,
+	model.GetChatInfo:           true,
+	model.SetChatRole:           true,
+	model.TransferChatOwnership: true,
+	model.MarkChatRead:          true,
+	model.AckMessages:           true,
+	model.Typing:                true,
+}
+
+// groupOnly lists the chat-scoped message types direct chats don't support, except
+// a member removing themself: that hides the direct chat until it is opened again.
+var groupOnly = map[model.MsgType]bool{
+	model.UpdateChat:            true,
+	model.DeleteChat:            true,
+	model.AddUserToChat:         true,
+	model.DeleteUserFromChat:    true,
+	model.SetChatRole:           true,
+	model.TransferChatOwnership: true,
+}
+
+// postingTypes lists the message types read-only members can't send.
+var postingTypes = map[model.MsgType]bool{
+	model.SendMessage: true,
+	model.Typing:      true,
+	model.AddReaction: true,
+}
+
+// messageScoped lists the message types whose To is a message id. Only members of
+// the chat the message belongs to may send them.
+var messageScoped = map[model.MsgType]bool{
+	model.UpdateMessage:     true,
+	model.GetMessageReaders: true,
+	model.GetThread:         true,
+	model.AddReaction:       true,
+	model.RemoveReaction:    true,
+}
+
+// targetChat returns the chat msg targets, or 0 for packets that don't target a chat.
+// Only message scoped packets need a lookup, it returns the response to send back when
+// their message is unknown.
+func (h *Hub) targetChat(msg *model.MessagePacketRequest) (uint64, *model.MessagePacketRequest) {
+	if msg.To == 0 || (!chatScoped[msg.MsgType] && !messageScoped[msg.MsgType]) {
+		return 0, nil
+	}
+	if chatScoped[msg.MsgType] {
+		return msg.To, nil
+	}
+	uow, err := h.storage.CreateUnitOfWork()
+	if err != nil {
+		h.logger.Error("failed to create unit of work", "error", err)
+		return 0, model.NewErrorPacket(msg.MsgType, msg, model.Internal, "internal error")
+	}
+	defer uow.Rollback()
+	chatID, err := uow.MessageRepository().GetChatID(msg.To)
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, model.NewErrorPacket(msg.MsgType, msg, model.NotFound, "not found")
+	}
+	if err != nil {
+		h.logger.Error("failed to get chat of message", "error", err, "message_id", msg.To)
+		return 0, model.NewErrorPacket(msg.MsgType, msg, model.Internal, "internal error")
+	}
+	return chatID, nil
+}
+
+// authorize checks that the sender of msg is a member of chatID, the chat targetChat
+// found, and that its role allows posting when msg adds content to the chat. It returns
+// the response to send back when the packet must not reach its handler and nil otherwise.
+// Packets without a target chat are left to the handlers to reject.
+func (h *Hub) authorize(chatID uint64, msg *model.MessagePacketRequest) *model.MessagePacketRequest {
+	if chatID == 0 {
+		return nil
+	}
+	uow, err := h.storage.CreateUnitOfWork()
+	if err != nil {
+		h.logger.Error("failed to create unit of work", "error", err)
+		return model.NewErrorPacket(msg.MsgType, msg, model.Internal, "internal error")
+	}
+	defer uow.Rollback()
+
+	role, err := uow.ChatRepository().GetRole(chatID, msg.From)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.logger.Warn("user is not a member of the chat", "type", msg.MsgType, "chat_id", chatID, "user_id", msg.From)
+		return model.NewErrorPacket(msg.MsgType, msg, model.Forbidden, "not a member of the chat")
+	}
+	if err != nil {
+		h.logger.Error("failed to check chat membership", "error", err, "chat_id", chatID, "user_id", msg.From)
+		return model.NewErrorPacket(msg.MsgType, msg, model.Internal, "internal error")
+	}
+	if groupOnly[msg.MsgType] {
+		chatType, err := uow.ChatRepository().GetChatType(chatID)
+		if err != nil {
+			h.logger.Error("failed to get chat type", "error", err, "chat_id", chatID)
+			return model.NewErrorPacket(msg.MsgType, msg, model.Internal, "internal error")
+		}
+		if chatType == model.Direct && !leaving(msg) {
+			h.logger.Warn("group operation on direct chat", "type", msg.MsgType, "chat_id", chatID, "user_id", msg.From)
+			return model.NewErrorPacket(msg.MsgType, msg, model.Forbidden, "not allowed in direct chats")
+		}
+	}
+	if postingTypes[msg.MsgType] && !role.CanPost() {
+		h.logger.Warn("user can't post in the chat", "type", msg.MsgType, "chat_id", chatID, "user_id", msg.From, "role", role)
+		return model.NewErrorPacket(msg.MsgType, msg, model.Forbidden, "read-only members can't post in the chat")
+	}
+	return nil
+}
+
+// leaving tells whether msg removes its sender from the chat.
+func leaving(msg *model.MessagePacketRequest) bool {
+	if msg.MsgType != model.DeleteUserFromChat {
+		return false
+	}
+	var strID string
+	_ = json.Unmarshal(msg.Data, &strID)
+	userID, err := strconv.ParseUint(strID, 10, 64)
+	return err == nil && userID == msg.From
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"testing"
+	"websocket_manager/internal/broker"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage/memory"
+)
+
+const (
+	owner uint64 = iota + 1
+	member
+	reader
+	outsider
+)
+
+// authorizationFixture is a group chat with a member of every kind, a direct chat of
+// owner and member, and a message in each.
+type authorizationFixture struct {
+	hub           *Hub
+	group         uint64
+	direct        uint64
+	groupMessage  uint64
+	directMessage uint64
+}
+
+func newAuthorizationFixture(t *testing.T) *authorizationFixture {
+	t.Helper()
+	st := memory.NewStorage()
+	for _, id := range []uint64{owner, member, reader, outsider} {
+		st.AddUser(id, "user")
+	}
+	uow, _ := st.CreateUnitOfWork()
+	defer uow.Rollback()
+	chats := uow.ChatRepository()
+	group := &model.Chat{Name: "group", CreatorID: owner}
+	if err := chats.CreateChat(group); err != nil {
+		t.Fatal(err)
+	}
+	for id, role := range map[uint64]model.ChatRole{owner: model.Owner, member: model.Member, reader: model.ReadOnly} {
+		if err := chats.AddUserToChat(&model.ChatUsers{ChatID: group.ID, UserID: id, Role: role}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	direct, _, err := chats.OpenDirectChat(owner, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupMessage := &model.Message{ChatID: group.ID, UserID: owner, Message: "hi"}
+	directMessage := &model.Message{ChatID: direct.ID, UserID: owner, Message: "hi"}
+	for _, msg := range []*model.Message{groupMessage, directMessage} {
+		if err := uow.MessageRepository().AddMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := uow.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mem := broker.NewMemory(broker.NewMemoryBus(), "node")
+	return &authorizationFixture{
+		hub:           NewHub(context.Background(), st, mem, mem, 10, logger),
+		group:         group.ID,
+		direct:        direct.ID,
+		groupMessage:  groupMessage.ID,
+		directMessage: directMessage.ID,
+	}
+}
+
+// check runs the packet through the same steps as route does before the handler.
+func (f *authorizationFixture) check(msg *model.MessagePacketRequest) *model.MessagePacketRequest {
+	chatID, ans := f.hub.targetChat(msg)
+	if ans != nil {
+		return ans
+	}
+	return f.hub.authorize(chatID, msg)
+}
+
+func TestAuthorize(t *testing.T) {
+	f := newAuthorizationFixture(t)
+	type testCase struct {
+		name string
+		from uint64
+		// inDirect targets the direct chat, or its message, instead of the group.
+		inDirect bool
+		// unknown targets a chat or message that doesn't exist.
+		unknown bool
+		want    model.ErrorCode
+	}
+
+	types := slices.Sorted(maps.Keys(chatScoped))
+	types = append(types, slices.Sorted(maps.Keys(messageScoped))...)
+	for _, msgType := range types {
+		// the read-only member is refused only where it would add content
+		var readerWant model.ErrorCode
+		if postingTypes[msgType] {
+			readerWant = model.Forbidden
+		}
+		var directWant model.ErrorCode
+		if groupOnly[msgType] {
+			directWant = model.Forbidden
+		}
+		unknownWant := model.Forbidden
+		if messageScoped[msgType] {
+			unknownWant = model.NotFound
+		}
+		cases := []testCase{
+			{name: "member", from: member},
+			{name: "owner", from: owner},
+			{name: "non-member", from: outsider, want: model.Forbidden},
+			{name: "read-only member", from: reader, want: readerWant},
+			{name: "direct chat", from: member, inDirect: true, want: directWant},
+			{name: "non-member of direct chat", from: reader, inDirect: true, want: model.Forbidden},
+			{name: "unknown target", from: member, unknown: true, want: unknownWant},
+		}
+		for _, tc := range cases {
+			t.Run(fmt.Sprintf("type %d/%s", msgType, tc.name), func(t *testing.T) {
+				to := f.group
+				switch {
+				case tc.unknown:
+					to = 1000
+				case messageScoped[msgType] && tc.inDirect:
+					to = f.directMessage
+				case messageScoped[msgType]:
+					to = f.groupMessage
+				case tc.inDirect:
+					to = f.direct
+				}
+				ans := f.check(&model.MessagePacketRequest{MsgType: msgType, RequestID: "req", From: tc.from, To: to})
+				if tc.want == "" {
+					if ans != nil {
+						t.Fatalf("refused with %+v", ans.Error)
+					}
+					return
+				}
+				if ans == nil || ans.Error == nil {
+					t.Fatalf("allowed, want %s", tc.want)
+				}
+				if ans.Error.Code != tc.want {
+					t.Errorf("code %s, want %s", ans.Error.Code, tc.want)
+				}
+				if ans.MsgType != msgType || ans.RequestID != "req" || ans.To != tc.from {
+					t.Errorf("response %+v doesn't answer the request", ans)
+				}
+			})
+		}
+	}
+}
+
+// TestAuthorizeUntargeted checks that packets without a target chat reach their
+// handlers, which answer them on their own.
+func TestAuthorizeUntargeted(t *testing.T) {
+	f := newAuthorizationFixture(t)
+	for _, msg := range []*model.MessagePacketRequest{
+		{MsgType: model.GetAllUserChats, From: outsider},
+		{MsgType: model.CreateChat, From: outsider, To: f.group},
+		{MsgType: model.SendMessage, From: outsider},
+		{MsgType: model.AddReaction, From: outsider},
+	} {
+		if ans := f.check(msg); ans != nil {
+			t.Errorf("type %d: refused with %+v", msg.MsgType, ans.Error)
+		}
+	}
+}
+
+// TestAuthorizeLeaveDirectChat checks that members may remove themselves from a
+// direct chat but nobody else.
+func TestAuthorizeLeaveDirectChat(t *testing.T) {
+	f := newAuthorizationFixture(t)
+	for _, tc := range []struct {
+		from uint64
+		data string
+		want model.ErrorCode
+	}{
+		{from: member, data: `"2"`},
+		{from: owner, data: `"1"`},
+		{from: owner, data: `"2"`, want: model.Forbidden},
+		{from: member, data: `"1"`, want: model.Forbidden},
+		{from: reader, data: `"3"`, want: model.Forbidden},
+	} {
+		ans := f.check(&model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, From: tc.from, To: f.direct, Data: []byte(tc.data)})
+		switch {
+		case tc.want == "" && ans != nil:
+			t.Errorf("user %d removing %s: refused with %+v", tc.from, tc.data, ans.Error)
+		case tc.want != "" && (ans == nil || ans.Error == nil || ans.Error.Code != tc.want):
+			t.Errorf("user %d removing %s: got %+v, want %s", tc.from, tc.data, ans, tc.want)
+		}
+	}
+}
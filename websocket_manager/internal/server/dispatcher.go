@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	dispatchShards    = 64
+	dispatchQueueSize = 256
+)
+
+// dispatcher runs jobs on a fixed set of workers. Jobs with the same key always land on
+// the same worker, so they run in the order they were dispatched while jobs with
+// other keys run in parallel.
+type dispatcher struct {
+	shards []chan func()
+	// pending counts the jobs dispatched and not finished yet.
+	pending sync.WaitGroup
+}
+
+func newDispatcher(shards int, queueSize int) *dispatcher {
+	d := &dispatcher{shards: make([]chan func(), shards)}
+	for i := range d.shards {
+		d.shards[i] = make(chan func(), queueSize)
+	}
+	return d
+}
+
+func (d *dispatcher) run(ctx context.Context) {
+	for _, shard := range d.shards {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-shard:
+					job()
+					d.pending.Done()
+				}
+			}
+		}()
+	}
+}
+
+// dispatch queues the job on the worker of the key. It blocks while that worker is
+// behind, which slows down only the connections feeding it.
+func (d *dispatcher) dispatch(ctx context.Context, key uint64, job func()) {
+	d.pending.Add(1)
+	select {
+	case d.shards[key%uint64(len(d.shards))] <- job:
+	case <-ctx.Done():
+		d.pending.Done()
+	}
+}
+
+// wait blocks until every dispatched job has finished or ctx is done.
+func (d *dispatcher) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
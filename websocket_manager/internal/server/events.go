@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	"websocket_manager/internal/model"
+)
+
+// chatMembers returns the ids of all members of the chat.
+func (h *Hub) chatMembers(chatID uint64) ([]uint64, error) {
+	uow, err := h.storage.CreateUnitOfWork()
+	if err != nil {
+		h.logger.Error("failed to create unit of work", "error", err)
+		return nil, err
+	}
+	defer uow.Rollback()
+	users, err := uow.ChatRepository().GetAllUsersIDInChat(chatID)
+	if err != nil {
+		h.logger.Error("failed to get chat members", "error", err, "chat_id", chatID)
+		return nil, err
+	}
+	return users, nil
+}
+
+// publishEvent sends an event that happened in the chat to the given members.
+func (h *Hub) publishEvent(members []uint64, msgType model.MsgType, from uint64, chatID uint64, event any) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		h.logger.Error("failed to marshal event", "error", err, "type", msgType)
+		return
+	}
+	h.logger.Debug("publish event", "type", msgType, "chat_id", chatID, "members", len(members))
+	h.sendToUsers(members, &model.MessagePacketRequest{MsgType: msgType, From: from, To: chatID, Data: data})
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+const maxAckedMessages = 200
+
+type AckMessagesRequest struct {
+	UserID uint64              `json:"-" validate:"required,min=1"`
+	ChatID uint64              `json:"-" validate:"required"`
+	IDs    []uint64            `json:"ids" validate:"required,min=1"`
+	Status model.ReceiptStatus `json:"status" validate:"required"`
+}
+
+// HandleAckMessages stores delivered or read receipts for messages of the chat. The
+// response data is the receipt event with the ids that changed, the hub passes it on
+// to the other members.
+func HandleAckMessages(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) *model.MessagePacketRequest {
+	var req AckMessagesRequest
+	_ = json.Unmarshal(msgPacketRequest.Data, &req)
+	req.UserID = msgPacketRequest.From
+	req.ChatID = msgPacketRequest.To
+	validator := validator.New()
+	if err := validator.Struct(req); err != nil || !req.Status.Valid() || len(req.IDs) > maxAckedMessages {
+		logger.Error("failed to validate request", "error", err, "status", req.Status, "count", len(req.IDs))
+		return model.NewErrorPacket(model.AckMessages, msgPacketRequest, model.ValidationFailed, "ids are required and status must be one of delivered, read")
+	}
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.AckMessages, msgPacketRequest, err)
+	}
+	defer uow.Rollback()
+	acked, err := uow.MessageRepository().AckMessages(req.ChatID, req.UserID, req.IDs, req.Status)
+	if err != nil {
+		logger.Error("failed to ack messages", "error", err)
+		return storageErrorResponse(model.AckMessages, msgPacketRequest, err)
+	}
+	// receipts move the chat cursors too, so unread counts follow the acks
+	if len(acked) != 0 {
+		lastID := acked[len(acked)-1]
+		if req.Status == model.Read {
+			err = uow.ChatRepository().MarkRead(req.ChatID, req.UserID, lastID)
+		} else {
+			err = uow.ChatRepository().MarkDelivered(req.ChatID, req.UserID, lastID)
+		}
+		if err != nil {
+			logger.Error("failed to move cursor", "error", err)
+			return storageErrorResponse(model.AckMessages, msgPacketRequest, err)
+		}
+	}
+	err = uow.Commit()
+	if err != nil {
+		logger.Error("failed to commit unit of work", "error", err)
+		return storageErrorResponse(model.AckMessages, msgPacketRequest, err)
+	}
+
+	response, err := json.Marshal(model.MessageReceiptEvent{UserID: req.UserID, Status: req.Status, IDs: acked})
+	if err != nil {
+		logger.Error("failed to marshal receipt", "error", err)
+		return model.NewErrorPacket(model.AckMessages, msgPacketRequest, model.Internal, "internal error")
+	}
+	logger.Info("messages acked", "count", len(acked), "status", req.Status, "chat_id", req.ChatID, "user_id", req.UserID)
+	return &model.MessagePacketRequest{MsgType: model.AckMessages, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
+}
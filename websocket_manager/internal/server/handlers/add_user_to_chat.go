@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"websocket_manager/internal/model"
 	"websocket_manager/internal/storage"
@@ -15,6 +16,8 @@ type AddUserToChatRequest struct {
 	UserID    uint64 `validate:"required"`
 }
 
+var errNotModerator = errors.New("user is not chat moderator")
+
 func HandleAddUserToChat(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) (*model.MessagePacketRequest, error) {
 	var userId uint64
 	_ = json.Unmarshal(msgPacketRequest.Data, &userId)
@@ -22,35 +25,35 @@ func HandleAddUserToChat(storage storage.Storage, msgPacketRequest *model.Messag
 	validator := validator.New()
 	if err := validator.Struct(req); err != nil {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.AddUserToChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}, err
+		return model.NewErrorPacket(model.AddUserToChat, msgPacketRequest, model.ValidationFailed, "chat id and user id are required"), err
 	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.AddUserToChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}, err
+		return storageErrorResponse(model.AddUserToChat, msgPacketRequest, err), err
 	}
 	defer uow.Rollback()
 	chatRepo := uow.ChatRepository()
-	ownerId, err := chatRepo.GetOwnerID(req.ChatID)
+	role, err := chatRepo.GetRole(req.ChatID, req.CreatorID)
 	if err != nil {
-		logger.Error("failed to get owner id", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.AddUserToChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}, err
+		logger.Error("failed to get role", "error", err)
+		return storageErrorResponse(model.AddUserToChat, msgPacketRequest, err), err
 	}
-	if ownerId != req.CreatorID {
-		logger.Error("user is not owner", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.AddUserToChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}, err
+	if !role.CanModerate() {
+		logger.Error("user is not chat moderator", "user_id", req.CreatorID, "role", role)
+		return model.NewErrorPacket(model.AddUserToChat, msgPacketRequest, model.Forbidden, "only the chat owner or admins can add users"), errNotModerator
 	}
-	chatUsers := &model.ChatUsers{ChatID: req.ChatID, UserID: req.UserID}
+	chatUsers := &model.ChatUsers{ChatID: req.ChatID, UserID: req.UserID, Role: model.Member}
 	err = chatRepo.AddUserToChat(chatUsers)
 	if err != nil {
 		logger.Error("failed to add user to chat", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.AddUserToChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}, err
+		return storageErrorResponse(model.AddUserToChat, msgPacketRequest, err), err
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.AddUserToChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}, err
+		return storageErrorResponse(model.AddUserToChat, msgPacketRequest, err), err
 	}
 	logger.Info("user added to chat", "chat_id", req.ChatID, "user_id", req.UserID)
-	return &model.MessagePacketRequest{MsgType: model.AddUserToChat, From: msgPacketRequest.From, To: msgPacketRequest.To, Data: json.RawMessage(model.Success)}, nil
+	return &model.MessagePacketRequest{MsgType: model.AddUserToChat, RequestID: msgPacketRequest.RequestID, From: msgPacketRequest.From, To: msgPacketRequest.To, Data: json.RawMessage(model.Success)}, nil
 }
@@ -21,12 +21,12 @@ func HandleCreateChat(storage storage.Storage, msgPacketRequest *model.MessagePa
 	validator := validator.New()
 	if err := validator.Struct(req); err != nil {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.CreateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.CreateChat, msgPacketRequest, model.ValidationFailed, "chat name must be 1 to 64 characters")
 	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.CreateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.CreateChat, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 	chatRepo := uow.ChatRepository()
@@ -34,20 +34,20 @@ func HandleCreateChat(storage storage.Storage, msgPacketRequest *model.MessagePa
 	err = chatRepo.CreateChat(chat)
 	if err != nil {
 		logger.Error("failed to create chat", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.CreateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.CreateChat, msgPacketRequest, err)
 	}
-	chatUser := &model.ChatUsers{ChatID: chat.ID, UserID: req.CreatorID}
+	chatUser := &model.ChatUsers{ChatID: chat.ID, UserID: req.CreatorID, Role: model.Owner}
 	err = chatRepo.AddUserToChat(chatUser)
 	if err != nil {
 		logger.Error("failed to add user to chat", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.CreateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.CreateChat, msgPacketRequest, err)
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.CreateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.CreateChat, msgPacketRequest, err)
 	}
 	logger.Info("chat created", "chat_id", chat.ID)
 	response, _ := json.Marshal(chat)
-	return &model.MessagePacketRequest{MsgType: model.CreateChat, From: 0, To: msgPacketRequest.From, Data: response}
+	return &model.MessagePacketRequest{MsgType: model.CreateChat, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
 }
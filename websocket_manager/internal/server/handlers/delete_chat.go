@@ -19,34 +19,34 @@ func HandleDeleteChat(storage storage.Storage, msgPacketRequest *model.MessagePa
 	validator := validator.New()
 	if err := validator.Struct(req); err != nil {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage(json.RawMessage("Internal Error"))}
+		return model.NewErrorPacket(model.DeleteChat, msgPacketRequest, model.ValidationFailed, "chat id is required")
 	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage(json.RawMessage("Internal Error"))}
+		return storageErrorResponse(model.DeleteChat, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 	chatRepo := uow.ChatRepository()
-	ownerId, err := chatRepo.GetOwnerID(req.ChatID)
+	role, err := chatRepo.GetRole(req.ChatID, req.CreatorID)
 	if err != nil {
-		logger.Error("failed to get owner id", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		logger.Error("failed to get role", "error", err)
+		return storageErrorResponse(model.DeleteChat, msgPacketRequest, err)
 	}
-	if ownerId != req.CreatorID {
-		logger.Error("user is not owner", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+	if role != model.Owner {
+		logger.Error("user is not owner", "user_id", req.CreatorID, "role", role)
+		return model.NewErrorPacket(model.DeleteChat, msgPacketRequest, model.Forbidden, "only the chat owner can delete the chat")
 	}
 	err = chatRepo.DeleteChat(req.ChatID)
 	if err != nil {
 		logger.Error("failed to delete chat", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.DeleteChat, msgPacketRequest, err)
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.DeleteChat, msgPacketRequest, err)
 	}
 	logger.Info("chat deleted", "chat_id", req.ChatID)
-	return &model.MessagePacketRequest{MsgType: model.DeleteChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Success")}
+	return &model.MessagePacketRequest{MsgType: model.DeleteChat, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: json.RawMessage(model.Success)}
 }
@@ -22,46 +22,55 @@ func HandleDeleteMessage(storage storage.Storage, msgPacketRequest *model.Messag
 	msgID, err := strconv.ParseUint(strId, 10, 64)
 	if err != nil {
 		logger.Error("failed to parse message id", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.DeleteMessage, msgPacketRequest, model.ValidationFailed, "message id must be a numeric string")
 	}
 	req := DeleteMessageRequest{DeletterID: msgPacketRequest.From, ChatID: msgPacketRequest.To, MsgID: msgID}
 	validator := validator.New()
 	if err := validator.Struct(req); err != nil {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.DeleteMessage, msgPacketRequest, model.ValidationFailed, "chat id and message id are required")
 	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.DeleteMessage, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 	msgRepo := uow.MessageRepository()
+	msgChatID, err := msgRepo.GetChatID(req.MsgID)
+	if err != nil {
+		logger.Error("failed to get message chat", "error", err)
+		return storageErrorResponse(model.DeleteMessage, msgPacketRequest, err)
+	}
+	if msgChatID != req.ChatID {
+		logger.Error("message is not in the chat", "id", req.MsgID, "chat_id", req.ChatID)
+		return model.NewErrorPacket(model.DeleteMessage, msgPacketRequest, model.NotFound, "not found")
+	}
 	msgSender, err := msgRepo.GetSenderID(req.MsgID)
 	if err != nil {
 		logger.Error("failed to get message sender", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.DeleteMessage, msgPacketRequest, err)
 	}
 	chatRepo := uow.ChatRepository()
-	ownerID, err := chatRepo.GetOwnerID(req.ChatID)
+	role, err := chatRepo.GetRole(req.ChatID, req.DeletterID)
 	if err != nil {
-		logger.Error("failed to get owner id", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		logger.Error("failed to get role", "error", err)
+		return storageErrorResponse(model.DeleteMessage, msgPacketRequest, err)
 	}
-	if msgSender != req.DeletterID || ownerID != req.DeletterID {
-		logger.Error("user is not message sender or chat owner", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+	if msgSender != req.DeletterID && !role.CanModerate() {
+		logger.Error("user is not message sender or chat moderator", "user_id", req.DeletterID, "role", role)
+		return model.NewErrorPacket(model.DeleteMessage, msgPacketRequest, model.Forbidden, "only the sender, the chat owner or admins can delete the message")
 	}
 	err = msgRepo.DeleteMessage(req.MsgID)
 	if err != nil {
 		logger.Error("failed to delete message", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.DeleteMessage, msgPacketRequest, err)
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.DeleteMessage, msgPacketRequest, err)
 	}
 	logger.Info("message deleted", "id", req.MsgID, "deleted_by", req.DeletterID)
-	return &model.MessagePacketRequest{MsgType: model.DeleteMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Success")}
+	return &model.MessagePacketRequest{MsgType: model.DeleteMessage, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: json.RawMessage(model.Success)}
 }
@@ -22,41 +22,48 @@ func HandleDeleteUserFromChat(storage storage.Storage, msgPacketRequest *model.M
 	userId, err := strconv.ParseUint(strId, 10, 64)
 	if err != nil {
 		logger.Error("failed to parse user id", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.DeleteUserFromChat, msgPacketRequest, model.ValidationFailed, "user id must be a numeric string")
 	}
 	req := DeleteUserFromChatRequest{CreatorID: msgPacketRequest.From, ChatID: msgPacketRequest.To, UserID: userId}
 	validator := validator.New()
 	if err := validator.Struct(req); err != nil {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.DeleteUserFromChat, msgPacketRequest, model.ValidationFailed, "chat id and user id are required")
 	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.DeleteUserFromChat, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 	chatRepo := uow.ChatRepository()
-	ownerId, err := chatRepo.GetOwnerID(req.ChatID)
+	role, err := chatRepo.GetRole(req.ChatID, req.CreatorID)
 	if err != nil {
-		logger.Error("failed to get owner id", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		logger.Error("failed to get role", "error", err)
+		return storageErrorResponse(model.DeleteUserFromChat, msgPacketRequest, err)
 	}
-	if ownerId != req.CreatorID {
-		logger.Error("user is not owner", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+	userRole, err := chatRepo.GetRole(req.ChatID, req.UserID)
+	if err != nil {
+		logger.Error("failed to get role of removed user", "error", err)
+		return storageErrorResponse(model.DeleteUserFromChat, msgPacketRequest, err)
+	}
+	// members may leave on their own, the owner has to transfer the ownership first
+	leaving := req.UserID == req.CreatorID && role != model.Owner
+	if !leaving && !(role.CanModerate() && role.Outranks(userRole)) {
+		logger.Error("user can't remove this member", "user_id", req.CreatorID, "role", role, "removed_role", userRole)
+		return model.NewErrorPacket(model.DeleteUserFromChat, msgPacketRequest, model.Forbidden, "only the chat owner or admins can remove lower ranked members")
 	}
 	chatUsers := &model.ChatUsers{ChatID: req.ChatID, UserID: req.UserID}
 	err = chatRepo.DeleteUserFromChat(chatUsers)
 	if err != nil {
 		logger.Error("failed to delete users from chat", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.DeleteUserFromChat, msgPacketRequest, err)
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.DeleteUserFromChat, msgPacketRequest, err)
 	}
 	logger.Info("user deleted from chat", "chat_id", req.ChatID, "user_id", req.UserID)
-	return &model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Success")}
+	return &model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: json.RawMessage(model.Success)}
 }
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"errors"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+// storageErrorResponse answers with not_found or conflict when the storage tells so
+// and hides every other storage failure behind a retryable internal error.
+func storageErrorResponse(msgType model.MsgType, req *model.MessagePacketRequest, err error) *model.MessagePacketRequest {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return model.NewErrorPacket(msgType, req, model.NotFound, "not found")
+	case errors.Is(err, storage.ErrConflict):
+		return model.NewErrorPacket(msgType, req, model.Conflict, "already exists")
+	default:
+		return model.NewErrorPacket(msgType, req, model.Internal, "internal error")
+	}
+}
@@ -19,26 +19,30 @@ func HandleGetAllMessagesInChat(storage storage.Storage, msgPacketRequest *model
 	validator := validator.New()
 	if err := validator.Struct(req); err != nil {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllMessagesInChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.GetAllMessagesInChat, msgPacketRequest, model.ValidationFailed, "chat id is required")
 	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllMessagesInChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.GetAllMessagesInChat, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 	msgRepo := uow.MessageRepository()
 	msgs, err := msgRepo.GetAllMessagesInChat(req.ChatID)
 	if err != nil {
 		logger.Error("failed to get messages", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllMessagesInChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.GetAllMessagesInChat, msgPacketRequest, err)
+	}
+	if err := loadMessageDetails(uow, msgs, req.UserID); err != nil {
+		logger.Error("failed to get message details", "error", err)
+		return storageErrorResponse(model.GetAllMessagesInChat, msgPacketRequest, err)
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllMessagesInChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.GetAllMessagesInChat, msgPacketRequest, err)
 	}
 	logger.Info("messages received", "count", len(msgs), "chat_id", req.ChatID, "user_id", req.UserID)
 	response, _ := json.Marshal(msgs)
-	return &model.MessagePacketRequest{MsgType: model.GetAllMessagesInChat, From: 0, To: msgPacketRequest.From, Data: response}
+	return &model.MessagePacketRequest{MsgType: model.GetAllMessagesInChat, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
 }
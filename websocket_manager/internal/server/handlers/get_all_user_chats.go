@@ -18,32 +18,32 @@ func HandleGetAllUserChats(storage storage.Storage, msgPacketRequest *model.Mess
 	validator := validator.New()
 	if err := validator.Struct(req); err != nil {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllUserChats, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.GetAllUserChats, msgPacketRequest, model.ValidationFailed, "user id is required")
 	}
 
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of websocket", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllUserChats, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.GetAllUserChats, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 	chatsRepo := uow.ChatRepository()
 	chats, err := chatsRepo.GetAllUserChats(req.UserId)
 	if err != nil {
 		logger.Error("failed to query all user chats", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllUserChats, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.GetAllUserChats, msgPacketRequest, err)
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit user chats", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllUserChats, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.GetAllUserChats, msgPacketRequest, err)
 	}
 	response, err := json.Marshal(chats)
 	if err != nil {
 		logger.Error("failed to marshal response to GetAllUserChats", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllUserChats, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.GetAllUserChats, msgPacketRequest, model.Internal, "internal error")
 	}
 
 	logger.Info("messages received", "count", len(chats), "user_id", req.UserId)
-	return &model.MessagePacketRequest{MsgType: model.GetAllUserChats, From: 0, To: msgPacketRequest.From, Data: response}
+	return &model.MessagePacketRequest{MsgType: model.GetAllUserChats, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
 }
@@ -19,26 +19,26 @@ func HandleGetLlUsersIDInChat(storage storage.Storage, msgPacketRequest *model.M
 	validator := validator.New()
 	if err := validator.Struct(req); err != nil {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllUsersIDInChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.GetAllUsersIDInChat, msgPacketRequest, model.ValidationFailed, "chat id is required")
 	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllUsersIDInChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.GetAllUsersIDInChat, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 	chatRepo := uow.ChatRepository()
-	chatUsersIDs := make([]uint64, 0)
-	chatUsersIDs, err = chatRepo.GetAllUsersIDInChat(req.ChatID)
+	chatUsersIDs, err := chatRepo.GetAllUsersIDInChat(req.ChatID)
 	if err != nil {
 		logger.Error("failed to get users from chat", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllUsersIDInChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.GetAllUsersIDInChat, msgPacketRequest, err)
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.GetAllUsersIDInChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.GetAllUsersIDInChat, msgPacketRequest, err)
 	}
 	logger.Info("users received", "count", len(chatUsersIDs), "chat_id", req.ChatID, "user_id", req.UserID)
-	return &model.MessagePacketRequest{MsgType: model.GetAllUsersIDInChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Success")}
+	response, _ := json.Marshal(chatUsersIDs)
+	return &model.MessagePacketRequest{MsgType: model.GetAllUsersIDInChat, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
 }
@@ -13,25 +13,29 @@ type GetChatInfoResponse struct {
 }
 
 func HandleGetChatInfo(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) *model.MessagePacketRequest {
+	if msgPacketRequest.To == 0 {
+		logger.Error("failed to validate request", "error", "chat id is required")
+		return model.NewErrorPacket(model.GetChatInfo, msgPacketRequest, model.ValidationFailed, "chat id is required")
+	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of websocket manager", "error", err)
-		return &model.MessagePacketRequest{}
+		return storageErrorResponse(model.GetChatInfo, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 
 	chatInfo, users, err := uow.ChatRepository().GetChatInfo(msgPacketRequest.To)
 	if err != nil {
 		logger.Error("failed to get chat info", "error", err)
-		return &model.MessagePacketRequest{}
+		return storageErrorResponse(model.GetChatInfo, msgPacketRequest, err)
 	}
 
 	rawResponse := GetChatInfoResponse{ChatInfo: chatInfo, Users: users}
 	response, err := json.Marshal(rawResponse)
 	if err != nil {
 		logger.Error("failed to marshal chat info", "error", err)
-		return &model.MessagePacketRequest{}
+		return model.NewErrorPacket(model.GetChatInfo, msgPacketRequest, model.Internal, "internal error")
 	}
 	logger.Info("chat information")
-	return &model.MessagePacketRequest{MsgType: model.GetChatInfo, From: msgPacketRequest.From, To: msgPacketRequest.To, Data: response}
+	return &model.MessagePacketRequest{MsgType: model.GetChatInfo, RequestID: msgPacketRequest.RequestID, From: msgPacketRequest.From, To: msgPacketRequest.To, Data: response}
 }
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+// HandleGetMessageReaders returns the receipts of the message in To, read ones first.
+func HandleGetMessageReaders(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) *model.MessagePacketRequest {
+	if msgPacketRequest.To == 0 {
+		logger.Error("failed to validate request", "id", msgPacketRequest.To)
+		return model.NewErrorPacket(model.GetMessageReaders, msgPacketRequest, model.ValidationFailed, "message id is required")
+	}
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.GetMessageReaders, msgPacketRequest, err)
+	}
+	defer uow.Rollback()
+	receipts, err := uow.MessageRepository().GetReceipts(msgPacketRequest.To)
+	if err != nil {
+		logger.Error("failed to get receipts", "error", err)
+		return storageErrorResponse(model.GetMessageReaders, msgPacketRequest, err)
+	}
+	response, err := json.Marshal(receipts)
+	if err != nil {
+		logger.Error("failed to marshal receipts", "error", err)
+		return model.NewErrorPacket(model.GetMessageReaders, msgPacketRequest, model.Internal, "internal error")
+	}
+	logger.Info("message readers received", "id", msgPacketRequest.To, "count", len(receipts))
+	return &model.MessagePacketRequest{MsgType: model.GetMessageReaders, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+const (
+	defaultMessagesPageSize = 50
+	maxMessagesPageSize     = 200
+)
+
+type GetMessagesPageRequest struct {
+	UserID   uint64 `json:"-" validate:"required,min=1"`
+	ChatID   uint64 `json:"-" validate:"required"`
+	BeforeID uint64 `json:"before,omitempty"`
+	AfterID  uint64 `json:"after,omitempty"`
+	Limit    int    `json:"limit,omitempty" validate:"min=0"`
+}
+
+type GetMessagesPageResponse struct {
+	Messages []model.Message `json:"messages"`
+	// NextCursor is the message id to pass as before (or after, when paging forward)
+	// to get the next page, it is omitted when there are no more messages.
+	NextCursor uint64 `json:"next_cursor,omitempty"`
+}
+
+func HandleGetMessagesPage(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) *model.MessagePacketRequest {
+	var req GetMessagesPageRequest
+	if len(msgPacketRequest.Data) != 0 {
+		if err := json.Unmarshal(msgPacketRequest.Data, &req); err != nil {
+			logger.Error("failed to parse request", "error", err)
+			return model.NewErrorPacket(model.GetMessagesPage, msgPacketRequest, model.ValidationFailed, "malformed page request")
+		}
+	}
+	req.UserID = msgPacketRequest.From
+	req.ChatID = msgPacketRequest.To
+	validator := validator.New()
+	if err := validator.Struct(req); err != nil || (req.BeforeID != 0 && req.AfterID != 0) {
+		logger.Error("failed to validate request", "error", err)
+		return model.NewErrorPacket(model.GetMessagesPage, msgPacketRequest, model.ValidationFailed, "chat id is required and only one of before and after can be set")
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultMessagesPageSize
+	}
+	req.Limit = min(req.Limit, maxMessagesPageSize)
+
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.GetMessagesPage, msgPacketRequest, err)
+	}
+	defer uow.Rollback()
+	msgRepo := uow.MessageRepository()
+	// one extra message tells whether there is a next page
+	msgs, err := msgRepo.GetMessagesPage(req.ChatID, req.BeforeID, req.AfterID, req.Limit+1)
+	if err != nil {
+		logger.Error("failed to get messages", "error", err)
+		return storageErrorResponse(model.GetMessagesPage, msgPacketRequest, err)
+	}
+	if err := loadMessageDetails(uow, msgs, req.UserID); err != nil {
+		logger.Error("failed to get message details", "error", err)
+		return storageErrorResponse(model.GetMessagesPage, msgPacketRequest, err)
+	}
+	err = uow.Commit()
+	if err != nil {
+		logger.Error("failed to commit unit of work", "error", err)
+		return storageErrorResponse(model.GetMessagesPage, msgPacketRequest, err)
+	}
+
+	page := GetMessagesPageResponse{Messages: msgs}
+	if len(msgs) > req.Limit {
+		if req.AfterID != 0 {
+			page.Messages = msgs[:req.Limit]
+			page.NextCursor = page.Messages[len(page.Messages)-1].ID
+		} else {
+			page.Messages = msgs[1:]
+			page.NextCursor = page.Messages[0].ID
+		}
+	}
+	response, err := json.Marshal(page)
+	if err != nil {
+		logger.Error("failed to marshal messages page", "error", err)
+		return model.NewErrorPacket(model.GetMessagesPage, msgPacketRequest, model.Internal, "internal error")
+	}
+	logger.Info("messages page received", "count", len(page.Messages), "chat_id", req.ChatID, "user_id", req.UserID, "next_cursor", page.NextCursor)
+	return &model.MessagePacketRequest{MsgType: model.GetMessagesPage, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+type GetThreadRequest struct {
+	UserID    uint64 `json:"-" validate:"required,min=1"`
+	MessageID uint64 `json:"-" validate:"required"`
+	AfterID   uint64 `json:"after,omitempty"`
+	Limit     int    `json:"limit,omitempty" validate:"min=0"`
+}
+
+type GetThreadResponse struct {
+	Root     *model.Message  `json:"root"`
+	Messages []model.Message `json:"messages"`
+	// NextCursor is the id to pass as after for the next replies, omitted at the end.
+	NextCursor uint64 `json:"next_cursor,omitempty"`
+}
+
+// HandleGetThread returns the thread of the message in To: its first message and a page
+// of replies, oldest first. To may be any message of the thread.
+func HandleGetThread(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) *model.MessagePacketRequest {
+	var req GetThreadRequest
+	if len(msgPacketRequest.Data) != 0 {
+		if err := json.Unmarshal(msgPacketRequest.Data, &req); err != nil {
+			logger.Error("failed to parse request", "error", err)
+			return model.NewErrorPacket(model.GetThread, msgPacketRequest, model.ValidationFailed, "malformed thread request")
+		}
+	}
+	req.UserID = msgPacketRequest.From
+	req.MessageID = msgPacketRequest.To
+	validator := validator.New()
+	if err := validator.Struct(req); err != nil {
+		logger.Error("failed to validate request", "error", err)
+		return model.NewErrorPacket(model.GetThread, msgPacketRequest, model.ValidationFailed, "message id is required")
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultMessagesPageSize
+	}
+	req.Limit = min(req.Limit, maxMessagesPageSize)
+
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.GetThread, msgPacketRequest, err)
+	}
+	defer uow.Rollback()
+	msgRepo := uow.MessageRepository()
+	root, err := msgRepo.GetMessage(req.MessageID)
+	if err != nil {
+		logger.Error("failed to get message", "error", err)
+		return storageErrorResponse(model.GetThread, msgPacketRequest, err)
+	}
+	if root.ThreadRootID != nil {
+		root, err = msgRepo.GetMessage(*root.ThreadRootID)
+		if err != nil {
+			logger.Error("failed to get thread root", "error", err)
+			return storageErrorResponse(model.GetThread, msgPacketRequest, err)
+		}
+	}
+	msgs, err := msgRepo.GetThread(root.ID, req.AfterID, req.Limit+1)
+	if err != nil {
+		logger.Error("failed to get thread", "error", err)
+		return storageErrorResponse(model.GetThread, msgPacketRequest, err)
+	}
+	thread := GetThreadResponse{Root: root, Messages: msgs}
+	if len(msgs) > req.Limit {
+		thread.Messages = msgs[:req.Limit]
+		thread.NextCursor = thread.Messages[len(thread.Messages)-1].ID
+	}
+	withRoot := append([]model.Message{*root}, thread.Messages...)
+	if err := loadMessageDetails(uow, withRoot, req.UserID); err != nil {
+		logger.Error("failed to get message details", "error", err)
+		return storageErrorResponse(model.GetThread, msgPacketRequest, err)
+	}
+	thread.Root, thread.Messages = &withRoot[0], withRoot[1:]
+	err = uow.Commit()
+	if err != nil {
+		logger.Error("failed to commit unit of work", "error", err)
+		return storageErrorResponse(model.GetThread, msgPacketRequest, err)
+	}
+
+	response, err := json.Marshal(thread)
+	if err != nil {
+		logger.Error("failed to marshal thread", "error", err)
+		return model.NewErrorPacket(model.GetThread, msgPacketRequest, model.Internal, "internal error")
+	}
+	logger.Info("thread received", "root_id", root.ID, "count", len(thread.Messages), "user_id", req.UserID)
+	return &model.MessagePacketRequest{MsgType: model.GetThread, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+// HandleGetUnreadCounts answers with the cursors and unread counts of every chat of
+// the user. The hub also pushes it to every new session.
+func HandleGetUnreadCounts(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) *model.MessagePacketRequest {
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.UnreadCounts, msgPacketRequest, err)
+	}
+	defer uow.Rollback()
+	cursors, err := uow.ChatRepository().GetCursors(msgPacketRequest.From)
+	if err != nil {
+		logger.Error("failed to get cursors", "error", err)
+		return storageErrorResponse(model.UnreadCounts, msgPacketRequest, err)
+	}
+	response, err := json.Marshal(cursors)
+	if err != nil {
+		logger.Error("failed to marshal cursors", "error", err)
+		return model.NewErrorPacket(model.UnreadCounts, msgPacketRequest, model.Internal, "internal error")
+	}
+	logger.Info("unread counts received", "chats", len(cursors), "user_id", msgPacketRequest.From)
+	return &model.MessagePacketRequest{MsgType: model.UnreadCounts, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
+}
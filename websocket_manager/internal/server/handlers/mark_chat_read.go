@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+type MarkChatReadRequest struct {
+	UserID uint64 `validate:"required,min=1"`
+	ChatID uint64 `validate:"required"`
+	MsgID  uint64 `validate:"required"`
+}
+
+// HandleMarkChatRead moves the read cursor of the user in the chat up to the message.
+func HandleMarkChatRead(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) *model.MessagePacketRequest {
+	var msgID uint64
+	_ = json.Unmarshal(msgPacketRequest.Data, &msgID)
+	req := MarkChatReadRequest{UserID: msgPacketRequest.From, ChatID: msgPacketRequest.To, MsgID: msgID}
+	validator := validator.New()
+	if err := validator.Struct(req); err != nil {
+		logger.Error("failed to validate request", "error", err)
+		return model.NewErrorPacket(model.MarkChatRead, msgPacketRequest, model.ValidationFailed, "chat id and message id are required")
+	}
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.MarkChatRead, msgPacketRequest, err)
+	}
+	defer uow.Rollback()
+	msgChatID, err := uow.MessageRepository().GetChatID(req.MsgID)
+	if err != nil {
+		logger.Error("failed to get message chat", "error", err)
+		return storageErrorResponse(model.MarkChatRead, msgPacketRequest, err)
+	}
+	if msgChatID != req.ChatID {
+		logger.Error("message is not in the chat", "id", req.MsgID, "chat_id", req.ChatID)
+		return model.NewErrorPacket(model.MarkChatRead, msgPacketRequest, model.NotFound, "not found")
+	}
+	err = uow.ChatRepository().MarkRead(req.ChatID, req.UserID, req.MsgID)
+	if err != nil {
+		logger.Error("failed to mark chat read", "error", err)
+		return storageErrorResponse(model.MarkChatRead, msgPacketRequest, err)
+	}
+	err = uow.Commit()
+	if err != nil {
+		logger.Error("failed to commit unit of work", "error", err)
+		return storageErrorResponse(model.MarkChatRead, msgPacketRequest, err)
+	}
+	logger.Info("chat read", "chat_id", req.ChatID, "user_id", req.UserID, "message_id", req.MsgID)
+	return &model.MessagePacketRequest{MsgType: model.MarkChatRead, RequestID: msgPacketRequest.RequestID, From: msgPacketRequest.From, To: msgPacketRequest.To, Data: msgPacketRequest.Data}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+// loadMessageDetails fills in the attachments and the reaction counts of the messages,
+// as seen by userID.
+func loadMessageDetails(uow storage.UnitOfWork, msgs []model.Message, userID uint64) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]uint64, 0, len(msgs))
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+	attachments, err := uow.AttachmentRepository().GetByMessages(ids)
+	if err != nil {
+		return err
+	}
+	reactions, err := uow.MessageRepository().GetReactions(ids, userID)
+	if err != nil {
+		return err
+	}
+	for i := range msgs {
+		msgs[i].Attachments = attachments[msgs[i].ID]
+		msgs[i].Reactions = reactions[msgs[i].ID]
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+type OpenDirectChatRequest struct {
+	UserID uint64 `validate:"required,min=1"`
+	PeerID uint64 `validate:"required,nefield=UserID"`
+}
+
+// HandleOpenDirectChat returns the direct chat of the sender with the user in Data,
+// creating it on first use, so opening it again never makes a duplicate. A sender who
+// left the chat is back in it, a peer who left stays out until they open it. created
+// is true when the chat is new and the peer should hear about it.
+func HandleOpenDirectChat(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) (*model.MessagePacketRequest, bool) {
+	var peerID uint64
+	_ = json.Unmarshal(msgPacketRequest.Data, &peerID)
+	req := OpenDirectChatRequest{UserID: msgPacketRequest.From, PeerID: peerID}
+	validator := validator.New()
+	if err := validator.Struct(req); err != nil {
+		logger.Error("failed to validate request", "error", err)
+		return model.NewErrorPacket(model.OpenDirectChat, msgPacketRequest, model.ValidationFailed, "the id of another user is required"), false
+	}
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.OpenDirectChat, msgPacketRequest, err), false
+	}
+	defer uow.Rollback()
+	chat, created, err := uow.ChatRepository().OpenDirectChat(req.UserID, req.PeerID)
+	if err != nil {
+		logger.Error("failed to open direct chat", "error", err)
+		return storageErrorResponse(model.OpenDirectChat, msgPacketRequest, err), false
+	}
+	err = uow.Commit()
+	if err != nil {
+		logger.Error("failed to commit unit of work", "error", err)
+		return storageErrorResponse(model.OpenDirectChat, msgPacketRequest, err), false
+	}
+	logger.Info("direct chat opened", "chat_id", chat.ID, "user_id", req.UserID, "peer_id", req.PeerID, "created", created)
+	response, _ := json.Marshal(chat)
+	return &model.MessagePacketRequest{MsgType: model.OpenDirectChat, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}, created
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"unicode"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+type ReactionRequest struct {
+	UserID    uint64 `validate:"required,min=1"`
+	MessageID uint64 `validate:"required"`
+	Emoji     string `validate:"required,max=32"`
+}
+
+// validEmoji keeps reactions to short printable strings, which emoji are offered is up
+// to the clients.
+func validEmoji(emoji string) bool {
+	for _, r := range emoji {
+		if !unicode.IsPrint(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleReaction adds or removes, depending on the packet type, the reaction of the
+// sender to the message in To. Data is the emoji. The response data is the reaction
+// event the hub passes on to the members of the chat when changed is true, adding a
+// reaction the sender already has succeeds without a change.
+func HandleReaction(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) (*model.MessagePacketRequest, bool) {
+	msgType := msgPacketRequest.MsgType
+	var emoji string
+	_ = json.Unmarshal(msgPacketRequest.Data, &emoji)
+	req := ReactionRequest{UserID: msgPacketRequest.From, MessageID: msgPacketRequest.To, Emoji: emoji}
+	validator := validator.New()
+	if err := validator.Struct(req); err != nil || !validEmoji(req.Emoji) {
+		logger.Error("failed to validate request", "error", err)
+		return model.NewErrorPacket(msgType, msgPacketRequest, model.ValidationFailed, "message id and an emoji of at most 32 bytes are required"), false
+	}
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(msgType, msgPacketRequest, err), false
+	}
+	defer uow.Rollback()
+	msgRepo := uow.MessageRepository()
+	changed := true
+	if msgType == model.AddReaction {
+		changed, err = msgRepo.AddReaction(req.MessageID, req.UserID, req.Emoji)
+	} else {
+		err = msgRepo.RemoveReaction(req.MessageID, req.UserID, req.Emoji)
+	}
+	if err != nil {
+		logger.Error("failed to change reaction", "error", err)
+		return storageErrorResponse(msgType, msgPacketRequest, err), false
+	}
+	err = uow.Commit()
+	if err != nil {
+		logger.Error("failed to commit unit of work", "error", err)
+		return storageErrorResponse(msgType, msgPacketRequest, err), false
+	}
+
+	data, err := json.Marshal(model.ReactionEvent{MessageID: req.MessageID, UserID: req.UserID, Emoji: req.Emoji})
+	if err != nil {
+		logger.Error("failed to marshal reaction", "error", err)
+		return model.NewErrorPacket(msgType, msgPacketRequest, model.Internal, "internal error"), false
+	}
+	logger.Info("reaction changed", "type", msgType, "message_id", req.MessageID, "user_id", req.UserID, "emoji", req.Emoji, "changed", changed)
+	return &model.MessagePacketRequest{MsgType: msgType, RequestID: msgPacketRequest.RequestID, From: msgPacketRequest.From, To: msgPacketRequest.To, Data: data}, changed
+}
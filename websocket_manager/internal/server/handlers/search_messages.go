@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+type SearchMessagesRequest struct {
+	UserID   uint64     `json:"-" validate:"required,min=1"`
+	Query    string     `json:"query" validate:"required,max=256"`
+	ChatID   uint64     `json:"chat_id,omitempty"`
+	SenderID uint64     `json:"sender_id,omitempty"`
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+	BeforeID uint64     `json:"before,omitempty"`
+	Limit    int        `json:"limit,omitempty" validate:"min=0"`
+}
+
+type SearchMessagesResponse struct {
+	Results []model.SearchResult `json:"results"`
+	// NextCursor is the id to pass as before for the next page, omitted at the end.
+	NextCursor uint64 `json:"next_cursor,omitempty"`
+}
+
+// HandleSearchMessages runs a full-text search over the chats of the sender, newest
+// matches first. The query takes web search syntax: "quoted phrases", or, -excluded.
+func HandleSearchMessages(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) *model.MessagePacketRequest {
+	var req SearchMessagesRequest
+	if err := json.Unmarshal(msgPacketRequest.Data, &req); err != nil {
+		logger.Error("failed to parse request", "error", err)
+		return model.NewErrorPacket(model.SearchMessages, msgPacketRequest, model.ValidationFailed, "malformed search request")
+	}
+	req.UserID = msgPacketRequest.From
+	validator := validator.New()
+	if err := validator.Struct(req); err != nil || (req.From != nil && req.To != nil && !req.From.Before(*req.To)) {
+		logger.Error("failed to validate request", "error", err)
+		return model.NewErrorPacket(model.SearchMessages, msgPacketRequest, model.ValidationFailed, "a query of at most 256 characters is required and from must be before to")
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultSearchPageSize
+	}
+	req.Limit = min(req.Limit, maxSearchPageSize)
+
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.SearchMessages, msgPacketRequest, err)
+	}
+	defer uow.Rollback()
+	search := &model.MessageSearch{Query: req.Query, ChatID: req.ChatID, SenderID: req.SenderID, From: req.From, To: req.To, BeforeID: req.BeforeID}
+	results, err := uow.MessageRepository().SearchMessages(req.UserID, search, req.Limit+1)
+	if err != nil {
+		logger.Error("failed to search messages", "error", err)
+		return storageErrorResponse(model.SearchMessages, msgPacketRequest, err)
+	}
+	err = uow.Commit()
+	if err != nil {
+		logger.Error("failed to commit unit of work", "error", err)
+		return storageErrorResponse(model.SearchMessages, msgPacketRequest, err)
+	}
+
+	page := SearchMessagesResponse{Results: results}
+	if len(results) > req.Limit {
+		page.Results = results[:req.Limit]
+		page.NextCursor = page.Results[len(page.Results)-1].Message.ID
+	}
+	response, err := json.Marshal(page)
+	if err != nil {
+		logger.Error("failed to marshal search results", "error", err)
+		return model.NewErrorPacket(model.SearchMessages, msgPacketRequest, model.Internal, "internal error")
+	}
+	logger.Info("messages searched", "count", len(page.Results), "user_id", req.UserID, "chat_id", req.ChatID)
+	return &model.MessagePacketRequest{MsgType: model.SearchMessages, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
+}
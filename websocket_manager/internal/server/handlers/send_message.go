@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"log/slog"
 	"websocket_manager/internal/model"
 	"websocket_manager/internal/storage"
@@ -11,38 +10,70 @@ import (
 )
 
 type SendMessageRequest struct {
-	SenderID uint64 `validate:"required,min=1"`
-	ChatID   uint64 `validate:"required"`
-	Message  string `validate:"required"`
+	SenderID uint64 `json:"-" validate:"required,min=1"`
+	ChatID   uint64 `json:"-" validate:"required"`
+	Message  string `json:"message"`
+	// Attachments are ids of files uploaded to /attachments by the sender.
+	Attachments []uint64 `json:"attachments,omitempty" validate:"max=10"`
+	// ReplyTo is the message of the chat this one answers, the reply joins its thread.
+	ReplyTo uint64 `json:"reply_to,omitempty"`
 }
 
+// HandleSendMessage stores a message. Data is either the text as a JSON string, answered
+// with the same string, or a {"message", "attachments", "reply_to"} object, answered
+// with the stored message.
 func HandleSendMessage(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) *model.MessagePacketRequest {
-	var message string
-	_ = json.Unmarshal(msgPacketRequest.Data, &message)
-	req := SendMessageRequest{SenderID: msgPacketRequest.From, ChatID: msgPacketRequest.To, Message: message}
+	var req SendMessageRequest
+	plainText := json.Unmarshal(msgPacketRequest.Data, &req.Message) == nil
+	if !plainText {
+		_ = json.Unmarshal(msgPacketRequest.Data, &req)
+	}
+	req.SenderID = msgPacketRequest.From
+	req.ChatID = msgPacketRequest.To
 	validator := validator.New()
-	if err := validator.Struct(req); err != nil {
+	if err := validator.Struct(req); err != nil || (req.Message == "" && len(req.Attachments) == 0) {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.SendMessage, msgPacketRequest, model.ValidationFailed, "chat id and a non-empty message or at most 10 attachments are required")
 	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.SendMessage, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 	messRepo := uow.MessageRepository()
 	msg := &model.Message{ChatID: req.ChatID, UserID: req.SenderID, Message: req.Message}
+	if req.ReplyTo != 0 {
+		msg.ReplyToID = &req.ReplyTo
+	}
 	err = messRepo.AddMessage(msg)
 	if err != nil {
 		logger.Error("failed to add message", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.SendMessage, msgPacketRequest, err)
+	}
+	if len(req.Attachments) != 0 {
+		msg.Attachments, err = uow.AttachmentRepository().AttachToMessage(req.Attachments, req.SenderID, msg.ID)
+		if err != nil {
+			logger.Error("failed to attach to message", "error", err)
+			return storageErrorResponse(model.SendMessage, msgPacketRequest, err)
+		}
+		// attachments of someone else or already sent can't be reused
+		if len(msg.Attachments) != len(req.Attachments) {
+			logger.Error("attachments not available", "requested", req.Attachments)
+			return model.NewErrorPacket(model.SendMessage, msgPacketRequest, model.NotFound, "attachment not found")
+		}
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.SendMessage, msgPacketRequest, err)
+	}
+	logger.Info("message added", "id", msg.ID, "chat_id", msg.ChatID, "user_id", msg.UserID, "message", msg.Message, "attachments", len(msg.Attachments))
+	var response []byte
+	if plainText {
+		response, _ = json.Marshal(msg.Message)
+	} else {
+		response, _ = json.Marshal(msg)
 	}
-	logger.Info("message added", "id", msg.ID, "chat_id", msg.ChatID, "user_id", msg.UserID, "message", msg.Message)
-	return &model.MessagePacketRequest{MsgType: model.SendMessage, From: msgPacketRequest.From, To: msgPacketRequest.To, Data: json.RawMessage(fmt.Sprintf(`"%s"`, message))}
+	return &model.MessagePacketRequest{MsgType: model.SendMessage, RequestID: msgPacketRequest.RequestID, From: msgPacketRequest.From, To: msgPacketRequest.To, Data: response}
 }
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+type SetChatRoleRequest struct {
+	SetterID uint64         `json:"-" validate:"required,min=1"`
+	ChatID   uint64         `json:"-" validate:"required"`
+	UserID   uint64         `json:"user_id" validate:"required"`
+	Role     model.ChatRole `json:"role" validate:"required"`
+}
+
+var errRoleNotAllowed = errors.New("user can't set this role")
+
+// HandleSetChatRole promotes or demotes a member. The owner can hand out admin, member
+// and read_only, admins only member and read_only, ownership is moved by
+// HandleTransferChatOwnership.
+func HandleSetChatRole(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) (*model.MessagePacketRequest, error) {
+	var req SetChatRoleRequest
+	_ = json.Unmarshal(msgPacketRequest.Data, &req)
+	req.SetterID = msgPacketRequest.From
+	req.ChatID = msgPacketRequest.To
+	validator := validator.New()
+	if err := validator.Struct(req); err != nil {
+		logger.Error("failed to validate request", "error", err)
+		return model.NewErrorPacket(model.SetChatRole, msgPacketRequest, model.ValidationFailed, "user id and role are required"), err
+	}
+	if !req.Role.Valid() || req.Role == model.Owner {
+		logger.Error("failed to validate request", "role", req.Role)
+		return model.NewErrorPacket(model.SetChatRole, msgPacketRequest, model.ValidationFailed, "role must be one of admin, member, read_only"), errRoleNotAllowed
+	}
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.SetChatRole, msgPacketRequest, err), err
+	}
+	defer uow.Rollback()
+	chatRepo := uow.ChatRepository()
+	role, err := chatRepo.GetRole(req.ChatID, req.SetterID)
+	if err != nil {
+		logger.Error("failed to get role", "error", err)
+		return storageErrorResponse(model.SetChatRole, msgPacketRequest, err), err
+	}
+	userRole, err := chatRepo.GetRole(req.ChatID, req.UserID)
+	if err != nil {
+		logger.Error("failed to get role of target user", "error", err)
+		return storageErrorResponse(model.SetChatRole, msgPacketRequest, err), err
+	}
+	if !role.CanModerate() || !role.Outranks(userRole) || !role.Outranks(req.Role) {
+		logger.Error("user can't set this role", "user_id", req.SetterID, "role", role, "target_role", userRole, "new_role", req.Role)
+		return model.NewErrorPacket(model.SetChatRole, msgPacketRequest, model.Forbidden, "only a higher ranked moderator can change this role"), errRoleNotAllowed
+	}
+	err = chatRepo.SetRole(&model.ChatUsers{ChatID: req.ChatID, UserID: req.UserID, Role: req.Role})
+	if err != nil {
+		logger.Error("failed to set role", "error", err)
+		return storageErrorResponse(model.SetChatRole, msgPacketRequest, err), err
+	}
+	err = uow.Commit()
+	if err != nil {
+		logger.Error("failed to commit unit of work", "error", err)
+		return storageErrorResponse(model.SetChatRole, msgPacketRequest, err), err
+	}
+	logger.Info("chat role changed", "chat_id", req.ChatID, "user_id", req.UserID, "role", req.Role)
+	return &model.MessagePacketRequest{MsgType: model.SetChatRole, RequestID: msgPacketRequest.RequestID, From: msgPacketRequest.From, To: msgPacketRequest.To, Data: json.RawMessage(model.Success)}, nil
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+type SyncCursor struct {
+	ChatID uint64 `json:"chat_id"`
+	After  uint64 `json:"after"`
+}
+
+type SyncSinceRequest struct {
+	UserID uint64 `json:"-" validate:"required,min=1"`
+	// Cursors are the last message ids the client has, chats without a cursor are
+	// synced from the last message delivered to the user.
+	Cursors []SyncCursor `json:"cursors,omitempty"`
+	Limit   int          `json:"limit,omitempty" validate:"min=0"`
+}
+
+type SyncedChat struct {
+	ChatID   uint64          `json:"chat_id"`
+	Messages []model.Message `json:"messages"`
+	// HasMore tells to continue with GetMessagesPage after the last message.
+	HasMore bool `json:"has_more"`
+}
+
+type SyncSinceResponse struct {
+	Chats []SyncedChat `json:"chats"`
+}
+
+// HandleSyncSince returns the messages newer than the client's cursor in every chat of
+// the user, at most limit per chat, and marks them delivered.
+func HandleSyncSince(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) *model.MessagePacketRequest {
+	var req SyncSinceRequest
+	if len(msgPacketRequest.Data) != 0 {
+		if err := json.Unmarshal(msgPacketRequest.Data, &req); err != nil {
+			logger.Error("failed to parse request", "error", err)
+			return model.NewErrorPacket(model.SyncSince, msgPacketRequest, model.ValidationFailed, "malformed sync request")
+		}
+	}
+	req.UserID = msgPacketRequest.From
+	validator := validator.New()
+	if err := validator.Struct(req); err != nil {
+		logger.Error("failed to validate request", "error", err)
+		return model.NewErrorPacket(model.SyncSince, msgPacketRequest, model.ValidationFailed, "limit can't be negative")
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultMessagesPageSize
+	}
+	req.Limit = min(req.Limit, maxMessagesPageSize)
+	clientCursors := make(map[uint64]uint64, len(req.Cursors))
+	for _, c := range req.Cursors {
+		clientCursors[c.ChatID] = c.After
+	}
+
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.SyncSince, msgPacketRequest, err)
+	}
+	defer uow.Rollback()
+	chatRepo := uow.ChatRepository()
+	msgRepo := uow.MessageRepository()
+	cursors, err := chatRepo.GetCursors(req.UserID)
+	if err != nil {
+		logger.Error("failed to get cursors", "error", err)
+		return storageErrorResponse(model.SyncSince, msgPacketRequest, err)
+	}
+
+	sync := SyncSinceResponse{Chats: make([]SyncedChat, 0)}
+	for _, cursor := range cursors {
+		after, ok := clientCursors[cursor.ChatID]
+		if !ok {
+			after = cursor.LastDeliveredMessageID
+		}
+		if cursor.LastMessageID <= after {
+			continue
+		}
+		msgs, err := msgRepo.GetMessagesPage(cursor.ChatID, 0, after, req.Limit+1)
+		if err != nil {
+			logger.Error("failed to get messages", "error", err, "chat_id", cursor.ChatID)
+			return storageErrorResponse(model.SyncSince, msgPacketRequest, err)
+		}
+		chat := SyncedChat{ChatID: cursor.ChatID, Messages: msgs}
+		if len(msgs) > req.Limit {
+			chat.Messages = msgs[:req.Limit]
+			chat.HasMore = true
+		}
+		if len(chat.Messages) == 0 {
+			continue
+		}
+		if err := loadMessageDetails(uow, chat.Messages, req.UserID); err != nil {
+			logger.Error("failed to get message details", "error", err, "chat_id", cursor.ChatID)
+			return storageErrorResponse(model.SyncSince, msgPacketRequest, err)
+		}
+		err = chatRepo.MarkDelivered(cursor.ChatID, req.UserID, chat.Messages[len(chat.Messages)-1].ID)
+		if err != nil {
+			logger.Error("failed to mark delivered", "error", err, "chat_id", cursor.ChatID)
+			return storageErrorResponse(model.SyncSince, msgPacketRequest, err)
+		}
+		sync.Chats = append(sync.Chats, chat)
+	}
+	err = uow.Commit()
+	if err != nil {
+		logger.Error("failed to commit unit of work", "error", err)
+		return storageErrorResponse(model.SyncSince, msgPacketRequest, err)
+	}
+
+	response, err := json.Marshal(sync)
+	if err != nil {
+		logger.Error("failed to marshal sync", "error", err)
+		return model.NewErrorPacket(model.SyncSince, msgPacketRequest, model.Internal, "internal error")
+	}
+	logger.Info("chats synced", "chats", len(sync.Chats), "user_id", req.UserID)
+	return &model.MessagePacketRequest{MsgType: model.SyncSince, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: response}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+type TransferChatOwnershipRequest struct {
+	OwnerID uint64 `validate:"required,min=1"`
+	ChatID  uint64 `validate:"required"`
+	UserID  uint64 `validate:"required,nefield=OwnerID"`
+}
+
+var errNotOwner = errors.New("user is not owner")
+
+// HandleTransferChatOwnership makes another member the owner of the chat, the previous
+// owner stays in the chat as admin.
+func HandleTransferChatOwnership(storage storage.Storage, msgPacketRequest *model.MessagePacketRequest, logger *slog.Logger) (*model.MessagePacketRequest, error) {
+	var userId uint64
+	_ = json.Unmarshal(msgPacketRequest.Data, &userId)
+	req := TransferChatOwnershipRequest{OwnerID: msgPacketRequest.From, ChatID: msgPacketRequest.To, UserID: userId}
+	validator := validator.New()
+	if err := validator.Struct(req); err != nil {
+		logger.Error("failed to validate request", "error", err)
+		return model.NewErrorPacket(model.TransferChatOwnership, msgPacketRequest, model.ValidationFailed, "chat id and id of another member are required"), err
+	}
+	uow, err := storage.CreateUnitOfWork()
+	if err != nil {
+		logger.Error("failed to create unit of work", "error", err)
+		return storageErrorResponse(model.TransferChatOwnership, msgPacketRequest, err), err
+	}
+	defer uow.Rollback()
+	chatRepo := uow.ChatRepository()
+	role, err := chatRepo.GetRole(req.ChatID, req.OwnerID)
+	if err != nil {
+		logger.Error("failed to get role", "error", err)
+		return storageErrorResponse(model.TransferChatOwnership, msgPacketRequest, err), err
+	}
+	if role != model.Owner {
+		logger.Error("user is not owner", "user_id", req.OwnerID, "role", role)
+		return model.NewErrorPacket(model.TransferChatOwnership, msgPacketRequest, model.Forbidden, "only the chat owner can transfer the ownership"), errNotOwner
+	}
+	err = chatRepo.TransferOwnership(req.ChatID, req.OwnerID, req.UserID)
+	if err != nil {
+		logger.Error("failed to transfer ownership", "error", err)
+		return storageErrorResponse(model.TransferChatOwnership, msgPacketRequest, err), err
+	}
+	err = uow.Commit()
+	if err != nil {
+		logger.Error("failed to commit unit of work", "error", err)
+		return storageErrorResponse(model.TransferChatOwnership, msgPacketRequest, err), err
+	}
+	logger.Info("chat ownership transferred", "chat_id", req.ChatID, "from", req.OwnerID, "to", req.UserID)
+	return &model.MessagePacketRequest{MsgType: model.TransferChatOwnership, RequestID: msgPacketRequest.RequestID, From: msgPacketRequest.From, To: msgPacketRequest.To, Data: json.RawMessage(model.Success)}, nil
+}
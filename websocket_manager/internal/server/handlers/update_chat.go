@@ -22,35 +22,35 @@ func HandleUpdateChat(storage storage.Storage, msgPacketRequest *model.MessagePa
 	validator := validator.New()
 	if err := validator.Struct(req); err != nil {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.UpdateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.UpdateChat, msgPacketRequest, model.ValidationFailed, "chat id is required and name must be 1 to 64 characters")
 	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.UpdateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.UpdateChat, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 	chatRepo := uow.ChatRepository()
-	ownerId, err := chatRepo.GetOwnerID(req.ChatID)
+	role, err := chatRepo.GetRole(req.ChatID, req.CreatorID)
 	if err != nil {
-		logger.Error("failed to get owner id", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.UpdateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		logger.Error("failed to get role", "error", err)
+		return storageErrorResponse(model.UpdateChat, msgPacketRequest, err)
 	}
-	if ownerId != req.CreatorID {
-		logger.Error("user is not owner", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.UpdateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+	if !role.CanModerate() {
+		logger.Error("user is not chat moderator", "user_id", req.CreatorID, "role", role)
+		return model.NewErrorPacket(model.UpdateChat, msgPacketRequest, model.Forbidden, "only the chat owner or admins can rename the chat")
 	}
 	chat := &model.Chat{CreatorID: req.CreatorID, ID: req.ChatID, Name: req.Name}
 	err = chatRepo.UpdateChat(chat)
 	if err != nil {
 		logger.Error("failed to update chat", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.UpdateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.UpdateChat, msgPacketRequest, err)
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.UpdateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.UpdateChat, msgPacketRequest, err)
 	}
 	logger.Info("chat updated", "chat_id", chat.ID, "name", chat.Name)
-	return &model.MessagePacketRequest{MsgType: model.UpdateChat, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Success")}
+	return &model.MessagePacketRequest{MsgType: model.UpdateChat, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: json.RawMessage(model.Success)}
 }
@@ -22,35 +22,35 @@ func HandleUpdateMessage(storage storage.Storage, msgPacketRequest *model.Messag
 	validator := validator.New()
 	if err := validator.Struct(req); err != nil {
 		logger.Error("failed to validate request", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return model.NewErrorPacket(model.UpdateMessage, msgPacketRequest, model.ValidationFailed, "message id and a non-empty message are required")
 	}
 	uow, err := storage.CreateUnitOfWork()
 	if err != nil {
 		logger.Error("failed to create unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.UpdateMessage, msgPacketRequest, err)
 	}
 	defer uow.Rollback()
 	msgRepo := uow.MessageRepository()
-	msgSender, err := msgRepo.GetSenderID(req.SenderID)
+	msgSender, err := msgRepo.GetSenderID(req.MsgID)
 	if err != nil {
 		logger.Error("failed to get message sender", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.UpdateMessage, msgPacketRequest, err)
 	}
 	if msgSender != req.SenderID {
-		logger.Error("user is not message sender", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		logger.Error("user is not message sender", "user_id", req.SenderID)
+		return model.NewErrorPacket(model.UpdateMessage, msgPacketRequest, model.Forbidden, "only the sender can edit the message")
 	}
 	msg := &model.Message{ID: req.MsgID, Message: req.Message}
 	err = msgRepo.UpdateMessage(msg)
 	if err != nil {
 		logger.Error("failed to add message", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.UpdateMessage, msgPacketRequest, err)
 	}
 	err = uow.Commit()
 	if err != nil {
 		logger.Error("failed to commit unit of work", "error", err)
-		return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Internal Error")}
+		return storageErrorResponse(model.UpdateMessage, msgPacketRequest, err)
 	}
 	logger.Info("message updated", "id", msg.ID, "chat_id", msg.ChatID, "user_id", msg.UserID, "message", msg.Message)
-	return &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msgPacketRequest.From, Data: json.RawMessage("Success")}
+	return &model.MessagePacketRequest{MsgType: model.UpdateMessage, RequestID: msgPacketRequest.RequestID, From: 0, To: msgPacketRequest.From, Data: json.RawMessage(model.Success)}
 }
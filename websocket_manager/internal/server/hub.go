@@ -5,32 +5,65 @@ import (
 	"encoding/json"
 	"errors"
 	"log/slog"
+	"slices"
+	"strconv"
 	"sync"
+	"time"
+	"websocket_manager/internal/broker"
+	"websocket_manager/internal/metrics"
 	"websocket_manager/internal/model"
 	"websocket_manager/internal/server/handlers"
 	"websocket_manager/internal/session"
 	"websocket_manager/internal/storage"
 )
 
-const (
-	maxClients = 52
-)
-
 type Hub struct {
-	context     context.Context
-	connections map[uint64]*session.Session
-	mu          *sync.Mutex
-	storage     storage.Storage
-	logger      *slog.Logger
+	context context.Context
+	cancel  context.CancelFunc
+	// draining is set once Shutdown starts, new sessions are refused from then on.
+	draining bool
+	// connections holds every live session of a user, one per connected device.
+	connections map[uint64]map[*session.Session]struct{}
+	clients     int
+	maxClients  int
+	// presence holds the status of connected users, offlineTimers the users whose
+	// last session is gone but who are still within the presence grace period.
+	presence      map[uint64]model.PresenceStatus
+	offlineTimers map[uint64]*time.Timer
+	mu            *sync.Mutex
+	// routeChanges holds the latest route of the users whose first session came or
+	// last one went, runRoutes applies them after routesChanged wakes it. Changes of a
+	// user coalesce, so the hub never waits on the router.
+	routeChanges  map[uint64]bool
+	routesChanged chan struct{}
+	// intake finds the chat of every packet in the order its sender sent them, then
+	// hands it to the dispatcher worker of that chat.
+	intake     *dispatcher
+	dispatcher *dispatcher
+	broker     broker.Broker
+	router     broker.Router
+	storage    storage.Storage
+	logger     *slog.Logger
 }
 
-func NewHub(context context.Context, storage storage.Storage, logger *slog.Logger) *Hub {
+func NewHub(ctx context.Context, storage storage.Storage, broker broker.Broker, router broker.Router, maxClients int, logger *slog.Logger) *Hub {
+	hubCtx, cancel := context.WithCancel(ctx)
 	return &Hub{
-		context:     context,
-		connections: make(map[uint64]*session.Session),
-		mu:          &sync.Mutex{},
-		storage:     storage,
-		logger:      logger,
+		context:       hubCtx,
+		cancel:        cancel,
+		connections:   make(map[uint64]map[*session.Session]struct{}),
+		maxClients:    maxClients,
+		presence:      make(map[uint64]model.PresenceStatus),
+		offlineTimers: make(map[uint64]*time.Timer),
+		mu:            &sync.Mutex{},
+		routeChanges:  make(map[uint64]bool),
+		routesChanged: make(chan struct{}, 1),
+		intake:        newDispatcher(dispatchShards, dispatchQueueSize),
+		dispatcher:    newDispatcher(dispatchShards, dispatchQueueSize),
+		broker:        broker,
+		router:        router,
+		storage:       storage,
+		logger:        logger,
 	}
 }
 
@@ -42,94 +75,326 @@ func (h *Hub) Logger() *slog.Logger {
 	return h.logger
 }
 
-func (h *Hub) Register(session *session.Session) error {
+func (h *Hub) Register(s *session.Session) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	if len(h.connections) >= maxClients {
+	if h.draining {
+		return errors.New("shutting down")
+	}
+	if h.clients >= h.maxClients {
+		metrics.RejectedSessions.Add(1)
 		return errors.New("too many clients")
 	}
-	h.connections[session.ID()] = session
+	sessions, ok := h.connections[s.ID()]
+	if !ok {
+		sessions = make(map[*session.Session]struct{})
+		h.connections[s.ID()] = sessions
+	}
+	sessions[s] = struct{}{}
+	h.clients++
+	metrics.Sessions.Add(1)
+	if len(sessions) == 1 {
+		h.changeRoute(s.ID(), true)
+		h.userConnected(s.ID())
+	}
+	h.logger.Debug("session registered", "user_id", s.ID(), "devices", len(sessions))
+	// the new device learns what it missed while offline, it can SyncSince from there
+	go h.pushUnreadCounts(s)
 	return nil
 }
 
-func (h *Hub) Unregister(session *session.Session) {
+func (h *Hub) pushUnreadCounts(s *session.Session) {
+	req := &model.MessagePacketRequest{MsgType: model.UnreadCounts, From: s.ID()}
+	s.Enqueue(handlers.HandleGetUnreadCounts(h.storage, req, h.logger.With("handler", "unread_counts", "from", s.ID())))
+}
+
+// Unregister removes only the given session, other devices of the same user stay connected.
+func (h *Hub) Unregister(s *session.Session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sessions, ok := h.connections[s.ID()]
+	if !ok {
+		return
+	}
+	if _, ok := sessions[s]; !ok {
+		return
+	}
+	s.Conn().Close()
+	delete(sessions, s)
+	h.clients--
+	metrics.Sessions.Add(-1)
+	if len(sessions) == 0 {
+		delete(h.connections, s.ID())
+		h.changeRoute(s.ID(), false)
+		h.userDisconnected(s.ID())
+	}
+	h.logger.Debug("session unregistered", "user_id", s.ID(), "devices", len(sessions))
+}
+
+// sessions returns a snapshot of the live sessions of the user, so packets can be
+// enqueued without holding the hub lock.
+func (h *Hub) sessions(userID uint64) []*session.Session {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.connections[session.ID()].Conn().Close()
-	delete(h.connections, session.ID())
+	sessions := make([]*session.Session, 0, len(h.connections[userID]))
+	for s := range h.connections[userID] {
+		sessions = append(sessions, s)
+	}
+	return sessions
 }
 
+// SendQueueStats reports how full the send queues of the sessions on this node are.
+func (h *Hub) SendQueueStats() any {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	total, longest := 0, 0
+	for _, sessions := range h.connections {
+		for s := range sessions {
+			depth := s.QueueDepth()
+			total += depth
+			longest = max(longest, depth)
+		}
+	}
+	return map[string]int{"total": total, "max": longest}
+}
+
+// sendToUser enqueues the packet to every device the user is connected from, on this
+// node and on the others.
+func (h *Hub) sendToUser(userID uint64, msg *model.MessagePacketRequest) {
+	h.sendToUsers([]uint64{userID}, msg)
+}
+
+// HandleMessage queues the packet and returns, the database is only touched by the
+// workers so readPump keeps reading.
 func (h *Hub) HandleMessage(msg *model.MessagePacketRequest) {
 	h.Logger().Info("got message", "type", msg.MsgType, "from", msg.From, "to", msg.To, "msg", msg.Data)
+	h.intake.dispatch(h.context, msg.From, func() { h.route(msg) })
+}
+
+// route dispatches the packet to the worker of its chat, which authorizes and handles
+// it. Packets of one chat are handled one after another so members see its events in
+// the order they were stored, packets outside chats keep the order of their sender.
+func (h *Hub) route(msg *model.MessagePacketRequest) {
+	chatID, ans := h.targetChat(msg)
+	if ans != nil {
+		h.sendToUser(msg.From, ans)
+		return
+	}
+	key := chatID
+	if key == 0 {
+		key = msg.From
+	}
+	h.dispatcher.dispatch(h.context, key, func() {
+		if ans := h.authorize(chatID, msg); ans != nil {
+			h.sendToUser(msg.From, ans)
+			return
+		}
+		h.handle(chatID, msg)
+	})
+}
 
+// handle runs the handler of the packet and fans the result out, chatID is the chat
+// the packet was authorized for.
+func (h *Hub) handle(chatID uint64, msg *model.MessagePacketRequest) {
 	switch msg.MsgType {
 	case model.SendMessage:
 		ans := handlers.HandleSendMessage(h.storage, msg, h.logger.With("handler", "send_message", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
-		// TODO: refactor probably
-		if uow, err := h.storage.CreateUnitOfWork(); err == nil {
-			users, err := uow.ChatRepository().GetAllUsersIDInChat(msg.To)
-			defer uow.Rollback()
-			if err != nil {
-				return
-			}
-			for _, u := range users {
-				if u == msg.From {
-					continue
-				}
-				if _, ok := h.connections[u]; ok {
-					h.logger.Info("send message to another user in the chat", u)
-					getMessage := &model.MessagePacketRequest{MsgType: model.GetMessage, From: msg.From, To: msg.To, Data: ans.Data}
-					h.connections[u].Enqueue(getMessage)
-				}
-			}
+		h.sendToUser(msg.From, ans)
+		if ans.Error != nil {
+			return
 		}
+		users, err := h.chatMembers(msg.To)
+		if err != nil {
+			return
+		}
+		others := slices.DeleteFunc(users, func(u uint64) bool { return u == msg.From })
+		getMessage := &model.MessagePacketRequest{MsgType: model.GetMessage, From: msg.From, To: msg.To, Data: ans.Data}
+		h.sendToUsers(others, getMessage)
+		h.logger.Info("send message to other users in the chat", "users", len(others))
 	case model.UpdateMessage:
 		ans := handlers.HandleUpdateMessage(h.storage, msg, h.logger.With("handler", "update_message", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
+		h.sendToUser(msg.From, ans)
+		if ans.Error != nil {
+			return
+		}
+
+		var message string
+		_ = json.Unmarshal(msg.Data, &message)
+		if members, err := h.chatMembers(chatID); err == nil {
+			h.publishEvent(members, model.MessageEdited, msg.From, chatID, model.MessageEditedEvent{ID: msg.To, Message: message})
+		}
 	case model.DeleteMessage:
 		ans := handlers.HandleDeleteMessage(h.storage, msg, h.logger.With("handler", "delete_message", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
-	case model.GetAllMessagesInChat: // TODO: should be limited to some reasonable amount
+		h.sendToUser(msg.From, ans)
+		if ans.Error != nil {
+			return
+		}
+
+		var strId string
+		_ = json.Unmarshal(msg.Data, &strId)
+		msgID, _ := strconv.ParseUint(strId, 10, 64)
+		if members, err := h.chatMembers(chatID); err == nil {
+			h.publishEvent(members, model.MessageDeleted, msg.From, chatID, model.MessageDeletedEvent{ID: msgID})
+		}
+	case model.GetAllMessagesInChat: // prefer GetMessagesPage, this one returns the whole history
 		ans := handlers.HandleGetAllMessagesInChat(h.storage, msg, h.logger.With("handler", "get_all_messages_in_chat", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
+		h.sendToUser(msg.From, ans)
 	case model.CreateChat:
 		ans := handlers.HandleCreateChat(h.storage, msg, h.logger.With("handler", "create_chat", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
+		h.sendToUser(msg.From, ans)
 	case model.UpdateChat:
 		ans := handlers.HandleUpdateChat(h.storage, msg, h.logger.With("handler", "update_chat", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
+		h.sendToUser(msg.From, ans)
+		if ans.Error != nil {
+			return
+		}
+
+		var name string
+		_ = json.Unmarshal(msg.Data, &name)
+		if members, err := h.chatMembers(chatID); err == nil {
+			h.publishEvent(members, model.ChatRenamed, msg.From, chatID, model.ChatRenamedEvent{Name: name})
+		}
 	case model.DeleteChat:
+		// members are gone together with the chat, so they are collected beforehand
+		members, err := h.chatMembers(chatID)
 		ans := handlers.HandleDeleteChat(h.storage, msg, h.logger.With("handler", "delete_chat", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
+		h.sendToUser(msg.From, ans)
+		if ans.Error != nil || err != nil {
+			return
+		}
+
+		h.publishEvent(members, model.ChatDeleted, msg.From, chatID, nil)
 	case model.AddUserToChat:
 		ans, err := handlers.HandleAddUserToChat(h.storage, msg, h.logger.With("handler", "add_user_to_chat", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
+		h.sendToUser(msg.From, ans)
 		if err != nil {
 			return
 		}
 
 		var userID uint64
 		_ = json.Unmarshal(msg.Data, &userID)
-		if _, ok := h.connections[userID]; ok {
-			answerToAnotherUser := &model.MessagePacketRequest{MsgType: model.AddUserToChat, From: msg.From, To: msg.To, Data: nil}
-			h.connections[userID].Enqueue(answerToAnotherUser)
+		answerToAnotherUser := &model.MessagePacketRequest{MsgType: model.AddUserToChat, From: msg.From, To: msg.To, Data: nil}
+		h.sendToUser(userID, answerToAnotherUser)
+		// the added user has just been told, the others learn from the event
+		if members, err := h.chatMembers(chatID); err == nil {
+			members = slices.DeleteFunc(members, func(id uint64) bool { return id == userID })
+			h.publishEvent(members, model.MemberAdded, msg.From, chatID, model.MemberEvent{UserID: userID, Role: model.Member})
 		}
 
+	case model.SetChatRole:
+		ans, err := handlers.HandleSetChatRole(h.storage, msg, h.logger.With("handler", "set_chat_role", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+		if err != nil {
+			return
+		}
+
+		var req handlers.SetChatRoleRequest
+		_ = json.Unmarshal(msg.Data, &req)
+		if members, err := h.chatMembers(chatID); err == nil {
+			h.publishEvent(members, model.MemberRoleChanged, msg.From, chatID, model.MemberEvent{UserID: req.UserID, Role: req.Role})
+		}
+	case model.TransferChatOwnership:
+		ans, err := handlers.HandleTransferChatOwnership(h.storage, msg, h.logger.With("handler", "transfer_chat_ownership", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+		if err != nil {
+			return
+		}
+
+		var userID uint64
+		_ = json.Unmarshal(msg.Data, &userID)
+		if members, err := h.chatMembers(chatID); err == nil {
+			h.publishEvent(members, model.MemberRoleChanged, msg.From, chatID, model.MemberEvent{UserID: userID, Role: model.Owner})
+			h.publishEvent(members, model.MemberRoleChanged, msg.From, chatID, model.MemberEvent{UserID: msg.From, Role: model.Admin})
+		}
 	case model.DeleteUserFromChat:
+		// the removed member is notified as well, so members are collected beforehand
+		members, err := h.chatMembers(chatID)
 		ans := handlers.HandleDeleteUserFromChat(h.storage, msg, h.logger.With("handler", "delete_user_from_chat", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
+		h.sendToUser(msg.From, ans)
+		if ans.Error != nil || err != nil {
+			return
+		}
+
+		var strId string
+		_ = json.Unmarshal(msg.Data, &strId)
+		userID, _ := strconv.ParseUint(strId, 10, 64)
+		h.publishEvent(members, model.MemberRemoved, msg.From, chatID, model.MemberRemovedEvent{UserID: userID})
 	case model.GetAllUsersIDInChat:
 		ans := handlers.HandleGetLlUsersIDInChat(h.storage, msg, h.logger.With("handler", "get_all_users_id_in_chat", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
+		h.sendToUser(msg.From, ans)
 	case model.GetAllUserChats:
 		ans := handlers.HandleGetAllUserChats(h.storage, msg, h.logger.With("handler", "get_all_user_chats", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
+		h.sendToUser(msg.From, ans)
 	case model.GetChatInfo:
 		ans := handlers.HandleGetChatInfo(h.storage, msg, h.logger.With("handler", "get_chat_info", "from", msg.From))
-		h.connections[msg.From].Enqueue(ans)
+		h.sendToUser(msg.From, ans)
+	case model.GetMessagesPage:
+		ans := handlers.HandleGetMessagesPage(h.storage, msg, h.logger.With("handler", "get_messages_page", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+	case model.UnreadCounts:
+		ans := handlers.HandleGetUnreadCounts(h.storage, msg, h.logger.With("handler", "unread_counts", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+	case model.SyncSince:
+		ans := handlers.HandleSyncSince(h.storage, msg, h.logger.With("handler", "sync_since", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+	case model.MarkChatRead:
+		ans := handlers.HandleMarkChatRead(h.storage, msg, h.logger.With("handler", "mark_chat_read", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+	case model.AckMessages:
+		ans := handlers.HandleAckMessages(h.storage, msg, h.logger.With("handler", "ack_messages", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+		if ans.Error != nil {
+			return
+		}
+
+		var receipt model.MessageReceiptEvent
+		if err := json.Unmarshal(ans.Data, &receipt); err != nil || len(receipt.IDs) == 0 {
+			return
+		}
+		if members, err := h.chatMembers(chatID); err == nil {
+			h.publishEvent(members, model.ReceiptUpdated, msg.From, chatID, receipt)
+		}
+	case model.GetMessageReaders:
+		ans := handlers.HandleGetMessageReaders(h.storage, msg, h.logger.With("handler", "get_message_readers", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+	case model.Typing:
+		h.relayTyping(chatID, msg)
+	case model.SetPresence:
+		h.sendToUser(msg.From, h.handleSetPresence(msg))
+	case model.GetPresence:
+		h.sendToUser(msg.From, h.handleGetPresence(msg))
+	case model.GetThread:
+		ans := handlers.HandleGetThread(h.storage, msg, h.logger.With("handler", "get_thread", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+	case model.AddReaction, model.RemoveReaction:
+		ans, changed := handlers.HandleReaction(h.storage, msg, h.logger.With("handler", "reaction", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+		if !changed {
+			return
+		}
+
+		var event model.MsgType = model.ReactionAdded
+		if msg.MsgType == model.RemoveReaction {
+			event = model.ReactionRemoved
+		}
+		if members, err := h.chatMembers(chatID); err == nil {
+			h.publishEvent(members, event, msg.From, chatID, json.RawMessage(ans.Data))
+		}
+	case model.SearchMessages:
+		ans := handlers.HandleSearchMessages(h.storage, msg, h.logger.With("handler", "search_messages", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+	case model.OpenDirectChat:
+		ans, created := handlers.HandleOpenDirectChat(h.storage, msg, h.logger.With("handler", "open_direct_chat", "from", msg.From))
+		h.sendToUser(msg.From, ans)
+		if !created {
+			return
+		}
+
+		var peerID uint64
+		_ = json.Unmarshal(msg.Data, &peerID)
+		h.sendToUser(peerID, &model.MessagePacketRequest{MsgType: model.OpenDirectChat, From: msg.From, To: peerID, Data: ans.Data})
 	default:
-		ans := &model.MessagePacketRequest{MsgType: model.SendMessage, From: 0, To: msg.From, Data: json.RawMessage("Internal Error")}
-		h.connections[msg.From].Enqueue(ans)
+		ans := model.NewErrorPacket(msg.MsgType, msg, model.ValidationFailed, "unknown message type")
+		h.sendToUser(msg.From, ans)
 	}
 }
@@ -0,0 +1,422 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/big"
+	wsjwt "messenger-shared/jwt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"websocket_manager/internal/broker"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/session"
+	"websocket_manager/internal/storage/memory"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+const testKeyID = "test"
+
+// testServer serves the hub over websockets like cmd/main.go does, with the keys of
+// tokens signed by key.
+type testServer struct {
+	hub *Hub
+	key *rsa.PrivateKey
+	url string
+}
+
+func newTestServer(t *testing.T, st *memory.Storage) *testServer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(jwks.Close)
+	keys := wsjwt.NewJWKSCache(jwks.URL, time.Minute)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mem := broker.NewMemory(broker.NewMemoryBus(), "node")
+	hub := NewHub(context.Background(), st, mem, mem, 10000, logger)
+	go hub.Run()
+	options := session.Options{MaxMessageSize: 4096, SendQueueSize: 1024, QueuePolicy: session.Disconnect}
+	ws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session.ServeWs(hub, keys, options, w, r)
+	}))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := hub.Shutdown(ctx); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+		ws.Close()
+	})
+	return &testServer{hub: hub, key: key, url: "ws" + strings.TrimPrefix(ws.URL, "http")}
+}
+
+func (s *testServer) dial(t *testing.T, userID uint64) *websocket.Conn {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": strconv.FormatUint(userID, 10), "iss": "auth_service"})
+	token.Header["kid"] = testKeyID
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(s.url, http.Header{"Authorization": {"Bearer " + signed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestHubConcurrentChats runs hundreds of sessions posting into their chats at once
+// and checks every member gets every message of its chat in the order it was stored,
+// while packets to chats of others are refused. Run it with -race.
+func TestHubConcurrentChats(t *testing.T) {
+	const (
+		chats          = 10
+		membersPerChat = 30
+		perMember      = 5
+	)
+	st := memory.NewStorage()
+	for userID := uint64(1); userID <= chats*membersPerChat; userID++ {
+		st.AddUser(userID, "user "+strconv.FormatUint(userID, 10))
+	}
+	chatIDs := make([]uint64, chats)
+	uow, _ := st.CreateUnitOfWork()
+	for i := range chatIDs {
+		creator := uint64(i*membersPerChat + 1)
+		chat := &model.Chat{Name: "chat " + strconv.Itoa(i), CreatorID: creator}
+		if err := uow.ChatRepository().CreateChat(chat); err != nil {
+			t.Fatal(err)
+		}
+		chatIDs[i] = chat.ID
+		for m := range membersPerChat {
+			role := model.Member
+			if m == 0 {
+				role = model.Owner
+			}
+			if err := uow.ChatRepository().AddUserToChat(&model.ChatUsers{ChatID: chat.ID, UserID: creator + uint64(m), Role: role}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := uow.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newTestServer(t, st)
+	conns := make(map[uint64]*websocket.Conn, chats*membersPerChat)
+	for i := range chats {
+		for m := range membersPerChat {
+			userID := uint64(i*membersPerChat + m + 1)
+			conns[userID] = srv.dial(t, userID)
+		}
+	}
+	for srv.hub.sessionCount() < len(conns) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	for userID, conn := range conns {
+		chatID := chatIDs[(userID-1)/membersPerChat]
+		foreignChatID := chatIDs[((userID-1)/membersPerChat+1)%chats]
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := range perMember {
+				data, _ := json.Marshal(map[string]string{"message": strconv.Itoa(n)})
+				if err := conn.WriteJSON(model.MessagePacketRequest{MsgType: model.SendMessage, To: chatID, Data: data}); err != nil {
+					t.Errorf("user %d: write: %v", userID, err)
+					return
+				}
+			}
+			data, _ := json.Marshal(map[string]string{"message": "intruder"})
+			if err := conn.WriteJSON(model.MessagePacketRequest{MsgType: model.SendMessage, RequestID: "foreign", To: foreignChatID, Data: data}); err != nil {
+				t.Errorf("user %d: write: %v", userID, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			acks, received, refused := 0, 0, 0
+			var lastID uint64
+			conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+			for acks < perMember || received < (membersPerChat-1)*perMember || refused < 1 {
+				var pkt model.MessagePacketRequest
+				if err := conn.ReadJSON(&pkt); err != nil {
+					t.Errorf("user %d: read after %d acks, %d messages: %v", userID, acks, received, err)
+					return
+				}
+				switch {
+				case pkt.RequestID == "foreign":
+					if pkt.Error == nil || pkt.Error.Code != model.Forbidden {
+						t.Errorf("user %d: posting to a foreign chat got %+v", userID, pkt)
+					}
+					refused++
+					continue
+				case pkt.MsgType == model.SendMessage && pkt.Error == nil:
+					acks++
+				case pkt.MsgType == model.GetMessage:
+					received++
+				default:
+					continue
+				}
+				var msg model.Message
+				if err := json.Unmarshal(pkt.Data, &msg); err != nil {
+					t.Errorf("user %d: bad message %s: %v", userID, pkt.Data, err)
+					return
+				}
+				if msg.ChatID != chatID {
+					t.Errorf("user %d: got message of chat %d, member of %d", userID, msg.ChatID, chatID)
+				}
+				if msg.ID <= lastID {
+					t.Errorf("user %d: message %d after %d", userID, msg.ID, lastID)
+				}
+				lastID = msg.ID
+			}
+		}()
+	}
+	wg.Wait()
+
+	uow, _ = st.CreateUnitOfWork()
+	defer uow.Rollback()
+	for _, chatID := range chatIDs {
+		messages, err := uow.MessageRepository().GetAllMessagesInChat(chatID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != membersPerChat*perMember {
+			t.Errorf("chat %d: %d messages stored, want %d", chatID, len(messages), membersPerChat*perMember)
+		}
+	}
+}
+
+// expect reads packets from conn until one of msgType arrives.
+func expect(t *testing.T, conn *websocket.Conn, msgType model.MsgType) *model.MessagePacketRequest {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var pkt model.MessagePacketRequest
+		if err := conn.ReadJSON(&pkt); err != nil {
+			t.Fatalf("waiting for type %d: %v", msgType, err)
+		}
+		if pkt.MsgType == msgType {
+			return &pkt
+		}
+	}
+}
+
+// TestHubMemberEvents checks that every member hears about joins and role changes,
+// not only the member they are about.
+func TestHubMemberEvents(t *testing.T) {
+	const (
+		chatOwner uint64 = iota + 1
+		promoted
+		bystander
+		joining
+	)
+	st := memory.NewStorage()
+	for _, id := range []uint64{chatOwner, promoted, bystander, joining} {
+		st.AddUser(id, "user")
+	}
+	uow, _ := st.CreateUnitOfWork()
+	chat := &model.Chat{Name: "chat", CreatorID: chatOwner}
+	if err := uow.ChatRepository().CreateChat(chat); err != nil {
+		t.Fatal(err)
+	}
+	for id, role := range map[uint64]model.ChatRole{chatOwner: model.Owner, promoted: model.Member, bystander: model.Member} {
+		if err := uow.ChatRepository().AddUserToChat(&model.ChatUsers{ChatID: chat.ID, UserID: id, Role: role}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := uow.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, st)
+	conns := map[uint64]*websocket.Conn{}
+	for _, id := range []uint64{chatOwner, promoted, bystander, joining} {
+		conns[id] = srv.dial(t, id)
+	}
+	for srv.hub.sessionCount() < len(conns) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	members := []uint64{chatOwner, promoted, bystander}
+
+	data, _ := json.Marshal(map[string]any{"user_id": promoted, "role": model.Admin})
+	if err := conns[chatOwner].WriteJSON(model.MessagePacketRequest{MsgType: model.SetChatRole, To: chat.ID, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range members {
+		var event model.MemberEvent
+		json.Unmarshal(expect(t, conns[id], model.MemberRoleChanged).Data, &event)
+		if event != (model.MemberEvent{UserID: promoted, Role: model.Admin}) {
+			t.Errorf("user %d: got %+v", id, event)
+		}
+	}
+
+	data, _ = json.Marshal(joining)
+	if err := conns[chatOwner].WriteJSON(model.MessagePacketRequest{MsgType: model.AddUserToChat, To: chat.ID, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, conns[joining], model.AddUserToChat)
+	for _, id := range members {
+		var event model.MemberEvent
+		json.Unmarshal(expect(t, conns[id], model.MemberAdded).Data, &event)
+		if event != (model.MemberEvent{UserID: joining, Role: model.Member}) {
+			t.Errorf("user %d: got %+v", id, event)
+		}
+	}
+
+	data, _ = json.Marshal(promoted)
+	if err := conns[chatOwner].WriteJSON(model.MessagePacketRequest{MsgType: model.TransferChatOwnership, To: chat.ID, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range append(members, joining) {
+		got := map[uint64]model.ChatRole{}
+		for range 2 {
+			var event model.MemberEvent
+			json.Unmarshal(expect(t, conns[id], model.MemberRoleChanged).Data, &event)
+			got[event.UserID] = event.Role
+		}
+		if got[promoted] != model.Owner || got[chatOwner] != model.Admin {
+			t.Errorf("user %d: got roles %v", id, got)
+		}
+	}
+}
+
+// TestHubReactionEvents checks that reacting twice with the same emoji tells the other
+// members once.
+func TestHubReactionEvents(t *testing.T) {
+	const (
+		reacting uint64 = iota + 1
+		watching
+	)
+	st := memory.NewStorage()
+	st.AddUser(reacting, "user")
+	st.AddUser(watching, "user")
+	uow, _ := st.CreateUnitOfWork()
+	chat := &model.Chat{Name: "chat", CreatorID: reacting}
+	if err := uow.ChatRepository().CreateChat(chat); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint64{reacting, watching} {
+		if err := uow.ChatRepository().AddUserToChat(&model.ChatUsers{ChatID: chat.ID, UserID: id, Role: model.Member}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	message := &model.Message{ChatID: chat.ID, UserID: watching, Message: "hi"}
+	if err := uow.MessageRepository().AddMessage(message); err != nil {
+		t.Fatal(err)
+	}
+	if err := uow.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, st)
+	conn, watcher := srv.dial(t, reacting), srv.dial(t, watching)
+	for srv.hub.sessionCount() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, msgType := range []model.MsgType{model.AddReaction, model.AddReaction, model.RemoveReaction} {
+		if err := conn.WriteJSON(model.MessagePacketRequest{MsgType: msgType, To: message.ID, Data: json.RawMessage(`"+1"`)}); err != nil {
+			t.Fatal(err)
+		}
+		if ans := expect(t, conn, msgType); ans.Error != nil {
+			t.Fatalf("type %d refused: %+v", msgType, ans.Error)
+		}
+	}
+	// the repeated reaction is answered but not passed on
+	var events []model.MsgType
+	watcher.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for !slices.Contains(events, model.ReactionRemoved) {
+		var pkt model.MessagePacketRequest
+		if err := watcher.ReadJSON(&pkt); err != nil {
+			t.Fatalf("after events %v: %v", events, err)
+		}
+		if pkt.MsgType == model.ReactionAdded || pkt.MsgType == model.ReactionRemoved {
+			events = append(events, pkt.MsgType)
+		}
+	}
+	if !slices.Equal(events, []model.MsgType{model.ReactionAdded, model.ReactionRemoved}) {
+		t.Errorf("got events %v, want one added and one removed", events)
+	}
+}
+
+// TestHubLeaveDirectChat checks that a member who left a direct chat stays out when
+// the peer opens it again, and is back once they open it themselves.
+func TestHubLeaveDirectChat(t *testing.T) {
+	const (
+		leaving uint64 = iota + 1
+		staying
+	)
+	st := memory.NewStorage()
+	st.AddUser(leaving, "user")
+	st.AddUser(staying, "user")
+	srv := newTestServer(t, st)
+	conns := map[uint64]*websocket.Conn{leaving: srv.dial(t, leaving), staying: srv.dial(t, staying)}
+	for srv.hub.sessionCount() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	open := func(from uint64, peer uint64) uint64 {
+		t.Helper()
+		data, _ := json.Marshal(peer)
+		if err := conns[from].WriteJSON(model.MessagePacketRequest{MsgType: model.OpenDirectChat, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+		ans := expect(t, conns[from], model.OpenDirectChat)
+		var chat model.Chat
+		if ans.Error != nil || json.Unmarshal(ans.Data, &chat) != nil {
+			t.Fatalf("user %d: open failed with %+v", from, ans)
+		}
+		return chat.ID
+	}
+	isMember := func(chatID uint64, userID uint64) bool {
+		t.Helper()
+		uow, _ := st.CreateUnitOfWork()
+		defer uow.Rollback()
+		_, err := uow.ChatRepository().GetRole(chatID, userID)
+		return err == nil
+	}
+
+	chatID := open(staying, leaving)
+	// the peer hears about the new chat
+	expect(t, conns[leaving], model.OpenDirectChat)
+	data, _ := json.Marshal(strconv.FormatUint(leaving, 10))
+	if err := conns[leaving].WriteJSON(model.MessagePacketRequest{MsgType: model.DeleteUserFromChat, To: chatID, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	if ans := expect(t, conns[leaving], model.DeleteUserFromChat); ans.Error != nil {
+		t.Fatalf("leaving refused: %+v", ans.Error)
+	}
+
+	if open(staying, leaving) != chatID {
+		t.Fatal("reopening made another chat")
+	}
+	if isMember(chatID, leaving) {
+		t.Error("the peer reopening pulled the member who left back in")
+	}
+	open(leaving, staying)
+	if !isMember(chatID, leaving) || !isMember(chatID, staying) {
+		t.Error("reopening didn't bring the member who left back")
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+	"websocket_manager/internal/model"
+)
+
+// presenceGracePeriod is how long a user without sessions still counts as online, so
+// a quick reconnect doesn't flash offline to everyone sharing a chat with the user.
+const presenceGracePeriod = 10 * time.Second
+
+// maxPresenceQuery is the largest number of users GetPresence answers for.
+const maxPresenceQuery = 200
+
+// userConnected is called with the hub lock held when the first session of the user
+// is registered.
+func (h *Hub) userConnected(userID uint64) {
+	if t, ok := h.offlineTimers[userID]; ok {
+		t.Stop()
+		delete(h.offlineTimers, userID)
+		h.logger.Debug("user reconnected within grace period", "user_id", userID)
+		return
+	}
+	h.presence[userID] = model.Online
+	go func() {
+		// the user may already be online through another node
+		if h.connectedElsewhere(userID) {
+			return
+		}
+		h.publishPresence(model.Presence{UserID: userID, Status: model.Online})
+	}()
+}
+
+// userDisconnected is called with the hub lock held when the last session of the user
+// is gone. The user goes offline unless a session comes back within the grace period.
+func (h *Hub) userDisconnected(userID uint64) {
+	var t *time.Timer
+	t = time.AfterFunc(presenceGracePeriod, func() {
+		h.mu.Lock()
+		if h.offlineTimers[userID] != t || len(h.connections[userID]) != 0 {
+			h.mu.Unlock()
+			return
+		}
+		delete(h.offlineTimers, userID)
+		delete(h.presence, userID)
+		h.mu.Unlock()
+
+		if h.connectedElsewhere(userID) {
+			return
+		}
+		lastSeen := time.Now()
+		h.saveLastSeen(userID, lastSeen)
+		h.publishPresence(model.Presence{UserID: userID, Status: model.Offline, LastSeenAt: &lastSeen})
+	})
+	h.offlineTimers[userID] = t
+}
+
+func (h *Hub) saveLastSeen(userID uint64, at time.Time) {
+	uow, err := h.storage.CreateUnitOfWork()
+	if err != nil {
+		h.logger.Error("failed to create unit of work", "error", err)
+		return
+	}
+	defer uow.Rollback()
+	if err := uow.UserRepository().UpdateLastSeen(userID, at); err != nil {
+		h.logger.Error("failed to update last seen", "error", err, "user_id", userID)
+		return
+	}
+	if err := uow.Commit(); err != nil {
+		h.logger.Error("failed to commit unit of work", "error", err)
+	}
+}
+
+// contacts returns the ids of the users sharing a chat with the user.
+func (h *Hub) contacts(userID uint64) ([]uint64, error) {
+	uow, err := h.storage.CreateUnitOfWork()
+	if err != nil {
+		h.logger.Error("failed to create unit of work", "error", err)
+		return nil, err
+	}
+	defer uow.Rollback()
+	contacts, err := uow.UserRepository().GetContactIDs(userID)
+	if err != nil {
+		h.logger.Error("failed to get contacts", "error", err, "user_id", userID)
+		return nil, err
+	}
+	return contacts, nil
+}
+
+// publishPresence tells the users sharing a chat with the user about the new status.
+func (h *Hub) publishPresence(presence model.Presence) {
+	contacts, err := h.contacts(presence.UserID)
+	if err != nil {
+		return
+	}
+	h.publishEvent(contacts, model.PresenceChanged, presence.UserID, 0, presence)
+}
+
+// handleSetPresence switches a connected user between online and away, offline only
+// comes from closing every session.
+func (h *Hub) handleSetPresence(msg *model.MessagePacketRequest) *model.MessagePacketRequest {
+	var status model.PresenceStatus
+	_ = json.Unmarshal(msg.Data, &status)
+	if status != model.Online && status != model.Away {
+		h.logger.Error("failed to validate request", "status", status)
+		return model.NewErrorPacket(model.SetPresence, msg, model.ValidationFailed, "status must be one of online, away")
+	}
+
+	h.mu.Lock()
+	previous, ok := h.presence[msg.From]
+	if ok {
+		h.presence[msg.From] = status
+	}
+	h.mu.Unlock()
+	if ok && previous != status {
+		go h.publishPresence(model.Presence{UserID: msg.From, Status: status})
+	}
+	return &model.MessagePacketRequest{MsgType: model.SetPresence, RequestID: msg.RequestID, From: 0, To: msg.From, Data: msg.Data}
+}
+
+// handleGetPresence answers with the presence of the requested users, users not sharing
+// a chat with the sender are left out.
+func (h *Hub) handleGetPresence(msg *model.MessagePacketRequest) *model.MessagePacketRequest {
+	var ids []uint64
+	if err := json.Unmarshal(msg.Data, &ids); err != nil || len(ids) == 0 || len(ids) > maxPresenceQuery {
+		h.logger.Error("failed to validate request", "error", err, "count", len(ids))
+		return model.NewErrorPacket(model.GetPresence, msg, model.ValidationFailed, "a list of at most 200 user ids is required")
+	}
+	contacts, err := h.contacts(msg.From)
+	if err != nil {
+		return model.NewErrorPacket(model.GetPresence, msg, model.Internal, "internal error")
+	}
+	ids = slices.DeleteFunc(ids, func(id uint64) bool { return !slices.Contains(contacts, id) })
+
+	presences := make([]model.Presence, 0, len(ids))
+	offline := make([]uint64, 0)
+	h.mu.Lock()
+	for _, id := range ids {
+		if status, ok := h.presence[id]; ok {
+			presences = append(presences, model.Presence{UserID: id, Status: status})
+		} else {
+			offline = append(offline, id)
+		}
+	}
+	h.mu.Unlock()
+
+	// users connected to other nodes are online, their away status stays with that node
+	if routes, err := h.router.Routes(h.context, offline); len(offline) != 0 && err == nil {
+		for node, users := range routes {
+			if node == h.broker.Node() {
+				continue
+			}
+			for _, id := range users {
+				if slices.Contains(offline, id) {
+					presences = append(presences, model.Presence{UserID: id, Status: model.Online})
+					offline = slices.DeleteFunc(offline, func(u uint64) bool { return u == id })
+				}
+			}
+		}
+	}
+	if len(offline) != 0 {
+		uow, err := h.storage.CreateUnitOfWork()
+		if err != nil {
+			h.logger.Error("failed to create unit of work", "error", err)
+			return model.NewErrorPacket(model.GetPresence, msg, model.Internal, "internal error")
+		}
+		defer uow.Rollback()
+		lastSeen, err := uow.UserRepository().GetLastSeen(offline)
+		if err != nil {
+			h.logger.Error("failed to get last seen", "error", err)
+			return model.NewErrorPacket(model.GetPresence, msg, model.Internal, "internal error")
+		}
+		for _, id := range offline {
+			presence := model.Presence{UserID: id, Status: model.Offline}
+			if at, ok := lastSeen[id]; ok {
+				presence.LastSeenAt = &at
+			}
+			presences = append(presences, presence)
+		}
+	}
+
+	data, err := json.Marshal(presences)
+	if err != nil {
+		h.logger.Error("failed to marshal presence", "error", err)
+		return model.NewErrorPacket(model.GetPresence, msg, model.Internal, "internal error")
+	}
+	return &model.MessagePacketRequest{MsgType: model.GetPresence, RequestID: msg.RequestID, From: 0, To: msg.From, Data: data}
+}
+
+// relayTyping passes a typing indicator on to the other online members of the chat.
+// It is never stored and never answered.
+func (h *Hub) relayTyping(chatID uint64, msg *model.MessagePacketRequest) {
+	event := model.TypingEvent{Typing: true}
+	if len(msg.Data) != 0 {
+		_ = json.Unmarshal(msg.Data, &event.Typing)
+	}
+	members, err := h.chatMembers(chatID)
+	if err != nil {
+		return
+	}
+	members = slices.DeleteFunc(members, func(u uint64) bool { return u == msg.From })
+	h.publishEvent(members, model.Typing, msg.From, chatID, event)
+}
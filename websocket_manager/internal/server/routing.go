@@ -0,0 +1,97 @@
+package server
+
+import (
+	"websocket_manager/internal/broker"
+	"websocket_manager/internal/model"
+)
+
+// Run starts the workers, keeps the routing table of this node up to date and
+// delivers the packets other nodes publish to it, until the hub context is done.
+func (h *Hub) Run() {
+	h.intake.run(h.context)
+	h.dispatcher.run(h.context)
+	go h.runRoutes()
+	if err := h.broker.Subscribe(h.context, h.deliverEnvelope); err != nil {
+		h.logger.Error("failed to subscribe", "error", err)
+	}
+}
+
+// changeRoute records that the user is now connected to this node or not, h.mu must
+// be held.
+func (h *Hub) changeRoute(userID uint64, connected bool) {
+	h.routeChanges[userID] = connected
+	select {
+	case h.routesChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (h *Hub) runRoutes() {
+	for {
+		select {
+		case <-h.context.Done():
+			return
+		case <-h.routesChanged:
+			h.mu.Lock()
+			changes := h.routeChanges
+			h.routeChanges = make(map[uint64]bool)
+			h.mu.Unlock()
+			for userID, connected := range changes {
+				if connected {
+					_ = h.router.Connect(h.context, userID)
+				} else {
+					_ = h.router.Disconnect(h.context, userID)
+				}
+			}
+		}
+	}
+}
+
+func (h *Hub) deliverEnvelope(envelope *broker.Envelope) {
+	h.deliverLocal(envelope.UserIDs, envelope.Packet)
+}
+
+// deliverLocal enqueues the packet to the sessions of the users on this node.
+func (h *Hub) deliverLocal(userIDs []uint64, msg *model.MessagePacketRequest) {
+	for _, u := range userIDs {
+		for _, s := range h.sessions(u) {
+			s.Enqueue(msg)
+		}
+	}
+}
+
+// sendToUsers enqueues the packet to every online device of the users. Sessions on
+// this node get it directly, the other nodes holding sessions of the users get it
+// through the broker.
+func (h *Hub) sendToUsers(userIDs []uint64, msg *model.MessagePacketRequest) {
+	if len(userIDs) == 0 {
+		return
+	}
+	h.deliverLocal(userIDs, msg)
+	routes, err := h.router.Routes(h.context, userIDs)
+	if err != nil {
+		return
+	}
+	for node, users := range routes {
+		if node == h.broker.Node() {
+			continue
+		}
+		if err := h.broker.Publish(h.context, node, &broker.Envelope{UserIDs: users, Packet: msg}); err != nil {
+			h.logger.Error("failed to publish to node", "error", err, "node", node, "users", len(users))
+		}
+	}
+}
+
+// connectedElsewhere tells whether other nodes hold sessions of the user.
+func (h *Hub) connectedElsewhere(userID uint64) bool {
+	routes, err := h.router.Routes(h.context, []uint64{userID})
+	if err != nil {
+		return false
+	}
+	for node := range routes {
+		if node != h.broker.Node() {
+			return true
+		}
+	}
+	return false
+}
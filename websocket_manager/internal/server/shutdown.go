@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"time"
+	"websocket_manager/internal/session"
+
+	"github.com/gorilla/websocket"
+)
+
+const drainPollInterval = 50 * time.Millisecond
+
+// Shutdown drains the hub: new sessions are refused, every session flushes its queue
+// and is closed with a going away frame so clients reconnect to another replica, and
+// the packets already being handled get to commit. It gives up when ctx is done and
+// stops the hub workers either way.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	defer h.cancel()
+
+	h.mu.Lock()
+	h.draining = true
+	sessions := make([]*session.Session, 0, h.clients)
+	for _, userSessions := range h.connections {
+		for s := range userSessions {
+			sessions = append(sessions, s)
+		}
+	}
+	h.mu.Unlock()
+	h.logger.Info("draining sessions", "sessions", len(sessions))
+	for _, s := range sessions {
+		s.Close(websocket.CloseGoingAway, "server shutting down, reconnect")
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for h.sessionCount() > 0 {
+		select {
+		case <-ctx.Done():
+			h.logger.Warn("sessions left after drain deadline", "sessions", h.sessionCount())
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	// packets still in the intake are dispatched before the dispatcher runs dry
+	if err := h.intake.wait(ctx); err != nil {
+		h.logger.Warn("packets still in flight after drain deadline")
+		return err
+	}
+	if err := h.dispatcher.wait(ctx); err != nil {
+		h.logger.Warn("packets still in flight after drain deadline")
+		return err
+	}
+	h.logger.Info("hub drained")
+	return nil
+}
+
+func (h *Hub) sessionCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.clients
+}
@@ -0,0 +1,64 @@
+package session
+
+import (
+	"fmt"
+	"messenger-shared/ratelimit"
+	"time"
+	"websocket_manager/internal/model"
+)
+
+// RateLimits throttle the packets of the peer. Session limits all packets of one
+// connection and is kept in Local, Types limit a user per MsgType across all of
+// their sessions through Store.
+type RateLimits struct {
+	Session ratelimit.Limit
+	// Default is the limit of the MsgTypes missing from Types.
+	Default ratelimit.Limit
+	Types   map[model.MsgType]ratelimit.Limit
+	Local   *ratelimit.Memory
+	Store   ratelimit.Store
+}
+
+// DefaultTypeLimits are the limits of packets that write or are expensive to answer.
+var DefaultTypeLimits = map[model.MsgType]ratelimit.Limit{
+	model.SendMessage:    {Rate: 5, Burst: 20},
+	model.Typing:         {Rate: 1, Burst: 3},
+	model.AddReaction:    {Rate: 2, Burst: 10},
+	model.SearchMessages: {Rate: 1, Burst: 5},
+	model.CreateChat:     {Rate: 0.1, Burst: 5},
+	model.OpenDirectChat: {Rate: 0.5, Burst: 10},
+}
+
+// allow takes a token for the packet from the session and the user buckets. A store
+// error lets the packet through, the store logs it.
+func (s *Session) allow(msgType model.MsgType) (bool, time.Duration) {
+	limits := s.options.RateLimits
+	if limits == nil {
+		return true, 0
+	}
+	ctx := s.hub.Context()
+	if ok, wait, err := limits.Local.Allow(ctx, s.limitKey(), limits.Session); err == nil && !ok {
+		return false, wait
+	}
+	limit, ok := limits.Types[msgType]
+	if !ok {
+		limit = limits.Default
+	}
+	ok, wait, err := limits.Store.Allow(ctx, fmt.Sprintf("ws:user:%d:%d", s.id, msgType), limit)
+	if err != nil {
+		return true, 0
+	}
+	return ok, wait
+}
+
+func (s *Session) limitKey() string {
+	return fmt.Sprintf("ws:session:%d", s.sessionID)
+}
+
+// forgetLimits drops the session bucket once the session is gone, the user buckets
+// outlive it.
+func (s *Session) forgetLimits() {
+	if limits := s.options.RateLimits; limits != nil {
+		limits.Local.Forget(s.limitKey())
+	}
+}
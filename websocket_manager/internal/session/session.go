@@ -3,9 +3,13 @@ package session
 import (
 	"context"
 	"log/slog"
+	"messenger-shared/jwt"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-	"websocket_manager/internal/jwt"
+	"websocket_manager/internal/metrics"
 	"websocket_manager/internal/model"
 
 	"github.com/gorilla/websocket"
@@ -20,11 +24,31 @@ const (
 
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
+)
+
+// sessionIDs numbers the sessions of this process.
+var sessionIDs atomic.Uint64
+
+// QueuePolicy is what Enqueue does when the send queue of a session is full.
+type QueuePolicy string
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 1024
+const (
+	// DropOldest throws away the oldest queued packet, the client catches up with SyncSince.
+	DropOldest QueuePolicy = "drop_oldest"
+	// Disconnect closes the session, the client reconnects and syncs.
+	Disconnect QueuePolicy = "disconnect"
 )
 
+// Options are the limits every session is created with.
+type Options struct {
+	// MaxMessageSize is the largest message allowed from the peer.
+	MaxMessageSize int64
+	SendQueueSize  int
+	QueuePolicy    QueuePolicy
+	// RateLimits throttle the packets of the peer, nil turns rate limiting off.
+	RateLimits *RateLimits
+}
+
 var (
 	newline = []byte{'\n'}
 	space   = []byte{' '}
@@ -43,13 +67,24 @@ type Hub interface {
 	HandleMessage(msg *model.MessagePacketRequest)
 }
 
-// Session
-// #TODO: fix problem when one user connect from different devices
+// Session is a single websocket connection of a user. A user connected from
+// several devices owns one Session per device.
 type Session struct {
 	hub  Hub
 	conn *websocket.Conn
 	id   uint64
-	send chan []byte
+	// sessionID tells the sessions of one user apart, it keys the session rate limit.
+	sessionID uint64
+	// send is bounded, a full queue is handled by the queue policy so a slow client
+	// never blocks the goroutine enqueueing to it.
+	send       chan []byte
+	done       chan struct{}
+	options    Options
+	disconnect sync.Once
+	// closing asks writePump to flush the queue and send closeFrame.
+	closing    chan struct{}
+	closeOnce  sync.Once
+	closeFrame []byte
 }
 
 func (s *Session) ID() uint64 {
@@ -66,7 +101,43 @@ func (s *Session) Enqueue(msgPkt *model.MessagePacketRequest) {
 		s.hub.Logger().Error("failed to convert message packet to bytes", "error", err)
 		return
 	}
-	s.send <- bytes
+	select {
+	case s.send <- bytes:
+		return
+	case <-s.done:
+		return
+	default:
+	}
+
+	switch s.options.QueuePolicy {
+	case DropOldest:
+		for {
+			select {
+			case <-s.send:
+				metrics.DroppedPackets.Add(1)
+			default:
+			}
+			select {
+			case s.send <- bytes:
+				return
+			case <-s.done:
+				return
+			default:
+			}
+		}
+	case Disconnect:
+		s.disconnect.Do(func() {
+			metrics.SlowConsumerDisconnects.Add(1)
+			s.hub.Logger().Warn("send queue full, disconnecting slow consumer", "user_id", s.id, "queue_size", s.options.SendQueueSize)
+			// readPump fails on the closed connection and unregisters the session
+			s.conn.Close()
+		})
+	}
+}
+
+// QueueDepth is the number of packets waiting to be written to the peer.
+func (s *Session) QueueDepth() int {
+	return len(s.send)
 }
 
 func (s *Session) writePump() {
@@ -81,22 +152,29 @@ func (s *Session) writePump() {
 			s.hub.Logger().Info("context closed")
 			return
 
-		case message, ok := <-s.send:
+		case <-s.done:
 			s.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				s.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				s.hub.Logger().Error("failed to write message", "error", "channel closed")
-				return
-			}
+			s.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
 
-			w, err := s.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				s.hub.Logger().Error("failed to get next writer", "error", err)
-				return
+		case <-s.closing:
+			for {
+				select {
+				case message := <-s.send:
+					if err := s.write(message); err != nil {
+						return
+					}
+					continue
+				default:
+				}
+				break
 			}
-			w.Write(message)
-			if err := w.Close(); err != nil {
-				s.hub.Logger().Error("failed to close writer", "error", err)
+			s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			s.conn.WriteMessage(websocket.CloseMessage, s.closeFrame)
+			return
+
+		case message := <-s.send:
+			if err := s.write(message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -109,14 +187,40 @@ func (s *Session) writePump() {
 
 }
 
+func (s *Session) write(message []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+	w, err := s.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		s.hub.Logger().Error("failed to get next writer", "error", err)
+		return err
+	}
+	w.Write(message)
+	if err := w.Close(); err != nil {
+		s.hub.Logger().Error("failed to close writer", "error", err)
+		return err
+	}
+	return nil
+}
+
+// Close writes the packets already queued, then a close frame with the code and
+// text, and closes the connection.
+func (s *Session) Close(code int, text string) {
+	s.closeOnce.Do(func() {
+		s.closeFrame = websocket.FormatCloseMessage(code, text)
+		close(s.closing)
+	})
+}
+
 func (s *Session) readPump() {
 	defer func() {
 		s.hub.Unregister(s)
+		s.forgetLimits()
 		s.conn.Close()
-		close(s.send)
+		close(s.done)
 	}()
 
-	s.conn.SetReadLimit(maxMessageSize)
+	s.conn.SetReadLimit(s.options.MaxMessageSize)
 	s.conn.SetReadDeadline(time.Now().Add(pongWait))
 	s.conn.SetPongHandler(func(string) error { s.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
@@ -134,22 +238,36 @@ func (s *Session) readPump() {
 				return
 			}
 			MessagePacketRequest, err := model.ByteToMessagePacketRequest(message)
+			// set before any error reply is built, replies are addressed to From
+			MessagePacketRequest.From = s.id
 			if err != nil {
 				s.hub.Logger().Error("failed to convert message to message packet", "error", err)
+				s.Enqueue(model.NewErrorPacket(MessagePacketRequest.MsgType, MessagePacketRequest, model.ValidationFailed, "malformed packet"))
+				continue
+			}
+			if ok, wait := s.allow(MessagePacketRequest.MsgType); !ok {
+				metrics.RateLimitedPackets.Add(1)
+				errPkt := model.NewErrorPacket(MessagePacketRequest.MsgType, MessagePacketRequest, model.RateLimited, "rate limit exceeded")
+				errPkt.Error.RetryAfterMs = wait.Milliseconds()
+				s.Enqueue(errPkt)
 				continue
 			}
-			MessagePacketRequest.From = s.id
 			s.hub.HandleMessage(MessagePacketRequest)
 		}
 	}
 }
 
-func ServeWs(hub Hub, w http.ResponseWriter, r *http.Request) {
-	tokenStr := r.Header.Get("Authorization")
-	tokenStr = tokenStr[len("Bearer "):]
-	id, err := jwt.ParseToken(tokenStr)
+func ServeWs(hub Hub, keys *jwt.JWKSCache, options Options, w http.ResponseWriter, r *http.Request) {
+	tokenStr, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		hub.Logger().Error("no authorization header")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := jwt.ParseToken(keys, tokenStr)
 	if err != nil {
 		hub.Logger().Error("failed to parse token", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
@@ -158,10 +276,11 @@ func ServeWs(hub Hub, w http.ResponseWriter, r *http.Request) {
 		hub.Logger().Error("failed to upgrade connection", "error", err)
 		return
 	}
-	session := &Session{hub: hub, conn: conn, id: id, send: make(chan []byte)}
+	session := &Session{hub: hub, conn: conn, id: id, sessionID: sessionIDs.Add(1), send: make(chan []byte, options.SendQueueSize), done: make(chan struct{}), closing: make(chan struct{}), options: options}
 
 	if err := session.hub.Register(session); err != nil {
 		hub.Logger().Error("failed to register connection", "error", err)
+		conn.Close()
 		return
 	}
 
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"cmp"
+	"slices"
+	"time"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+type AttachmentRepository struct {
+	uow *UnitOfWork
+}
+
+func (repo *AttachmentRepository) CreateAttachment(attachment *model.Attachment) error {
+	st := repo.uow.state
+	st.lastAttachmentID++
+	attachment.ID = st.lastAttachmentID
+	attachment.CreatedAt = time.Now()
+	stored := *attachment
+	st.attachments[attachment.ID] = &stored
+	repo.uow.onRollback(func() {
+		delete(st.attachments, attachment.ID)
+		st.lastAttachmentID--
+	})
+	return nil
+}
+
+func (repo *AttachmentRepository) AttachToMessage(ids []uint64, userID uint64, messageID uint64) ([]model.Attachment, error) {
+	st := repo.uow.state
+	if _, ok := st.messages[messageID]; !ok {
+		return nil, storage.ErrNotFound
+	}
+	attachments := make([]model.Attachment, 0, len(ids))
+	for _, id := range ids {
+		attachment, ok := st.attachments[id]
+		if !ok || attachment.UserID != userID || attachment.MessageID != nil {
+			continue
+		}
+		linked := messageID
+		attachment.MessageID = &linked
+		repo.uow.onRollback(func() { attachment.MessageID = nil })
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, nil
+}
+
+func (repo *AttachmentRepository) GetAttachment(id uint64) (*model.Attachment, uint64, error) {
+	st := repo.uow.state
+	stored, ok := st.attachments[id]
+	if !ok {
+		return nil, 0, storage.ErrNotFound
+	}
+	attachment := *stored
+	if attachment.MessageID == nil {
+		return &attachment, 0, nil
+	}
+	return &attachment, st.messages[*attachment.MessageID].ChatID, nil
+}
+
+func (repo *AttachmentRepository) GetByMessages(messageIDs []uint64) (map[uint64][]model.Attachment, error) {
+	attachments := make(map[uint64][]model.Attachment)
+	for _, attachment := range repo.uow.state.attachments {
+		if attachment.MessageID != nil && slices.Contains(messageIDs, *attachment.MessageID) {
+			attachments[*attachment.MessageID] = append(attachments[*attachment.MessageID], *attachment)
+		}
+	}
+	for id := range attachments {
+		slices.SortFunc(attachments[id], func(a, b model.Attachment) int { return cmp.Compare(a.ID, b.ID) })
+	}
+	return attachments, nil
+}
+
+func (repo *AttachmentRepository) DeleteUnlinked(before time.Time) (int64, error) {
+	var deleted int64
+	for _, attachment := range repo.uow.state.attachments {
+		if attachment.MessageID == nil && attachment.CreatedAt.Before(before) {
+			repo.deleteAttachment(attachment)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// deleteAttachment removes the attachment and queues its blob for removal.
+func (repo *AttachmentRepository) deleteAttachment(attachment *model.Attachment) {
+	st := repo.uow.state
+	delete(st.attachments, attachment.ID)
+	st.deletedBlobs = append(st.deletedBlobs, attachment.StorageKey)
+	repo.uow.onRollback(func() {
+		st.attachments[attachment.ID] = attachment
+		st.deletedBlobs = st.deletedBlobs[:len(st.deletedBlobs)-1]
+	})
+}
+
+func (repo *AttachmentRepository) GetDeletedBlobs(limit int) ([]string, error) {
+	st := repo.uow.state
+	return slices.Clone(st.deletedBlobs[:min(limit, len(st.deletedBlobs))]), nil
+}
+
+func (repo *AttachmentRepository) ForgetDeletedBlobs(keys []string) error {
+	st := repo.uow.state
+	old := st.deletedBlobs
+	st.deletedBlobs = slices.DeleteFunc(slices.Clone(old), func(key string) bool { return slices.Contains(keys, key) })
+	repo.uow.onRollback(func() { st.deletedBlobs = old })
+	return nil
+}
@@ -0,0 +1,271 @@
+package memory
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+type ChatRepository struct {
+	uow *UnitOfWork
+}
+
+func (repo *ChatRepository) GetAllUserChats(id uint64) ([]model.Chat, error) {
+	st := repo.uow.state
+	chats := make([]model.Chat, 0)
+	for chatID, members := range st.members {
+		if _, ok := members[id]; ok {
+			chats = append(chats, *st.chats[chatID])
+		}
+	}
+	slices.SortFunc(chats, func(a, b model.Chat) int { return cmp.Compare(a.ID, b.ID) })
+	return chats, nil
+}
+
+func (repo *ChatRepository) CreateChat(chat *model.Chat) error {
+	chat.Type = model.Group
+	repo.insertChat(chat)
+	return nil
+}
+
+func (repo *ChatRepository) insertChat(chat *model.Chat) {
+	st := repo.uow.state
+	st.lastChatID++
+	chat.ID = st.lastChatID
+	chat.CreatedAt, chat.UpdatedAt = time.Now(), time.Now()
+	stored := *chat
+	st.chats[chat.ID] = &stored
+	st.members[chat.ID] = make(map[uint64]*member)
+	repo.uow.onRollback(func() {
+		delete(st.chats, chat.ID)
+		delete(st.members, chat.ID)
+		st.lastChatID--
+	})
+}
+
+func (repo *ChatRepository) OpenDirectChat(userID uint64, peerID uint64) (*model.Chat, bool, error) {
+	st := repo.uow.state
+	key := fmt.Sprintf("%d:%d", min(userID, peerID), max(userID, peerID))
+	if id, ok := st.directKeys[key]; ok {
+		chat := *st.chats[id]
+		if _, ok := st.members[id][userID]; !ok {
+			st.members[id][userID] = &member{role: model.Member}
+			repo.uow.onRollback(func() { delete(st.members[id], userID) })
+		}
+		return &chat, false, nil
+	}
+	chat := &model.Chat{Type: model.Direct, CreatorID: userID}
+	repo.insertChat(chat)
+	st.directKeys[key] = chat.ID
+	repo.uow.onRollback(func() { delete(st.directKeys, key) })
+	st.members[chat.ID][userID] = &member{role: model.Member}
+	st.members[chat.ID][peerID] = &member{role: model.Member}
+	return chat, true, nil
+}
+
+func (repo *ChatRepository) GetChatType(id uint64) (model.ChatType, error) {
+	chat, ok := repo.uow.state.chats[id]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	return chat.Type, nil
+}
+
+func (repo *ChatRepository) UpdateChat(chat *model.Chat) error {
+	stored, ok := repo.uow.state.chats[chat.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	old := *stored
+	stored.Name, stored.UpdatedAt = chat.Name, time.Now()
+	repo.uow.onRollback(func() { *stored = old })
+	return nil
+}
+
+func (repo *ChatRepository) DeleteChat(id uint64) error {
+	st := repo.uow.state
+	chat, ok := st.chats[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	for msgID, msg := range st.messages {
+		if msg.ChatID == id {
+			repo.uow.messageRepo.deleteMessage(msgID)
+		}
+	}
+	members := st.members[id]
+	delete(st.chats, id)
+	delete(st.members, id)
+	key := directKey(st, id)
+	if key != "" {
+		delete(st.directKeys, key)
+	}
+	repo.uow.onRollback(func() {
+		st.chats[id] = chat
+		st.members[id] = members
+		if key != "" {
+			st.directKeys[key] = id
+		}
+	})
+	return nil
+}
+
+func directKey(st *state, chatID uint64) string {
+	for key, id := range st.directKeys {
+		if id == chatID {
+			return key
+		}
+	}
+	return ""
+}
+
+func (repo *ChatRepository) AddUserToChat(chatUsers *model.ChatUsers) error {
+	if chatUsers.Role == "" {
+		chatUsers.Role = model.Member
+	}
+	members, ok := repo.uow.state.members[chatUsers.ChatID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if _, ok := members[chatUsers.UserID]; ok {
+		return storage.ErrConflict
+	}
+	members[chatUsers.UserID] = &member{role: chatUsers.Role}
+	repo.uow.onRollback(func() { delete(members, chatUsers.UserID) })
+	return nil
+}
+
+func (repo *ChatRepository) DeleteUserFromChat(chatUsers *model.ChatUsers) error {
+	members := repo.uow.state.members[chatUsers.ChatID]
+	m, ok := members[chatUsers.UserID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	delete(members, chatUsers.UserID)
+	repo.uow.onRollback(func() { members[chatUsers.UserID] = m })
+	return nil
+}
+
+func (repo *ChatRepository) GetAllUsersIDInChat(id uint64) ([]uint64, error) {
+	ids := make([]uint64, 0, len(repo.uow.state.members[id]))
+	for userID := range repo.uow.state.members[id] {
+		ids = append(ids, userID)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (repo *ChatRepository) IsMember(chatID uint64, userID uint64) (bool, error) {
+	_, ok := repo.uow.state.members[chatID][userID]
+	return ok, nil
+}
+
+func (repo *ChatRepository) GetRole(chatID uint64, userID uint64) (model.ChatRole, error) {
+	m, ok := repo.uow.state.members[chatID][userID]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	return m.role, nil
+}
+
+func (repo *ChatRepository) SetRole(chatUsers *model.ChatUsers) error {
+	m, ok := repo.uow.state.members[chatUsers.ChatID][chatUsers.UserID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	repo.setRole(m, chatUsers.Role)
+	return nil
+}
+
+func (repo *ChatRepository) setRole(m *member, role model.ChatRole) {
+	old := m.role
+	m.role = role
+	repo.uow.onRollback(func() { m.role = old })
+}
+
+func (repo *ChatRepository) TransferOwnership(chatID uint64, fromUserID uint64, toUserID uint64) error {
+	members := repo.uow.state.members[chatID]
+	if from, ok := members[fromUserID]; ok {
+		repo.setRole(from, model.Admin)
+	}
+	to, ok := members[toUserID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	repo.setRole(to, model.Owner)
+	if chat, ok := repo.uow.state.chats[chatID]; ok {
+		old := *chat
+		chat.CreatorID, chat.UpdatedAt = toUserID, time.Now()
+		repo.uow.onRollback(func() { *chat = old })
+	}
+	return nil
+}
+
+func (repo *ChatRepository) GetChatInfo(id uint64) (*model.Chat, []model.User, error) {
+	st := repo.uow.state
+	stored, ok := st.chats[id]
+	if !ok {
+		return nil, nil, storage.ErrNotFound
+	}
+	chat := *stored
+	users := make([]model.User, 0, len(st.members[id]))
+	for userID, m := range st.members[id] {
+		u := model.User{Id: userID, Role: m.role}
+		if named, ok := st.users[userID]; ok {
+			u.Name = named.name
+		}
+		users = append(users, u)
+	}
+	slices.SortFunc(users, func(a, b model.User) int { return cmp.Compare(a.Id, b.Id) })
+	return &chat, users, nil
+}
+
+func (repo *ChatRepository) GetCursors(userID uint64) ([]model.ChatCursor, error) {
+	st := repo.uow.state
+	cursors := make([]model.ChatCursor, 0)
+	for chatID, members := range st.members {
+		m, ok := members[userID]
+		if !ok {
+			continue
+		}
+		cursor := model.ChatCursor{ChatID: chatID, LastReadMessageID: m.lastRead, LastDeliveredMessageID: m.lastDelivered}
+		for _, msg := range st.messages {
+			if msg.ChatID != chatID {
+				continue
+			}
+			cursor.LastMessageID = max(cursor.LastMessageID, msg.ID)
+			if msg.ID > m.lastRead && msg.UserID != userID {
+				cursor.UnreadCount++
+			}
+		}
+		cursors = append(cursors, cursor)
+	}
+	slices.SortFunc(cursors, func(a, b model.ChatCursor) int { return cmp.Compare(a.ChatID, b.ChatID) })
+	return cursors, nil
+}
+
+func (repo *ChatRepository) MarkDelivered(chatID uint64, userID uint64, messageID uint64) error {
+	m, ok := repo.uow.state.members[chatID][userID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	old := *m
+	m.lastDelivered = max(m.lastDelivered, messageID)
+	repo.uow.onRollback(func() { *m = old })
+	return nil
+}
+
+func (repo *ChatRepository) MarkRead(chatID uint64, userID uint64, messageID uint64) error {
+	m, ok := repo.uow.state.members[chatID][userID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	old := *m
+	m.lastRead = max(m.lastRead, messageID)
+	m.lastDelivered = max(m.lastDelivered, messageID)
+	repo.uow.onRollback(func() { *m = old })
+	return nil
+}
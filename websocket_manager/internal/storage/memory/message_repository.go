@@ -0,0 +1,328 @@
+package memory
+
+import (
+	"cmp"
+	"html"
+	"slices"
+	"strings"
+	"time"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+type MessageRepository struct {
+	uow *UnitOfWork
+}
+
+// view copies the stored message with its reply count.
+func (repo *MessageRepository) view(msg *model.Message) model.Message {
+	view := *msg
+	view.ReplyCount = 0
+	for _, other := range repo.uow.state.messages {
+		if other.ThreadRootID != nil && *other.ThreadRootID == msg.ID {
+			view.ReplyCount++
+		}
+	}
+	return view
+}
+
+// filter returns the messages keep accepts ordered by id.
+func (repo *MessageRepository) filter(keep func(*model.Message) bool) []model.Message {
+	msgs := make([]model.Message, 0)
+	for _, msg := range repo.uow.state.messages {
+		if keep(msg) {
+			msgs = append(msgs, repo.view(msg))
+		}
+	}
+	slices.SortFunc(msgs, func(a, b model.Message) int { return cmp.Compare(a.ID, b.ID) })
+	return msgs
+}
+
+func (repo *MessageRepository) AddMessage(msg *model.Message) error {
+	st := repo.uow.state
+	if _, ok := st.chats[msg.ChatID]; !ok {
+		return storage.ErrNotFound
+	}
+	msg.ThreadRootID = nil
+	if msg.ReplyToID != nil {
+		parent, ok := st.messages[*msg.ReplyToID]
+		if !ok || parent.ChatID != msg.ChatID {
+			return storage.ErrNotFound
+		}
+		root := parent.ID
+		if parent.ThreadRootID != nil {
+			root = *parent.ThreadRootID
+		}
+		msg.ThreadRootID = &root
+	}
+	st.lastMessageID++
+	msg.ID = st.lastMessageID
+	msg.CreatedAt, msg.UpdatedAt = time.Now(), time.Now()
+	stored := *msg
+	stored.Attachments, stored.Reactions = nil, nil
+	st.messages[msg.ID] = &stored
+	repo.uow.onRollback(func() {
+		delete(st.messages, msg.ID)
+		st.lastMessageID--
+	})
+	return nil
+}
+
+func (repo *MessageRepository) UpdateMessage(msg *model.Message) error {
+	stored, ok := repo.uow.state.messages[msg.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	old := *stored
+	stored.Message, stored.UpdatedAt = msg.Message, time.Now()
+	repo.uow.onRollback(func() { *stored = old })
+	return nil
+}
+
+func (repo *MessageRepository) DeleteMessage(id uint64) error {
+	if _, ok := repo.uow.state.messages[id]; !ok {
+		return storage.ErrNotFound
+	}
+	repo.deleteMessage(id)
+	return nil
+}
+
+// deleteMessage removes the message with the rows referencing it: receipts, reactions
+// and attachments. Replies to it lose reply_to_id and the replies of the thread it
+// starts lose thread_root_id, they stay in the chat as plain messages.
+func (repo *MessageRepository) deleteMessage(id uint64) {
+	st := repo.uow.state
+	msg, ok := st.messages[id]
+	if !ok {
+		return
+	}
+	for _, other := range st.messages {
+		if other.ReplyToID != nil && *other.ReplyToID == id {
+			old := other.ReplyToID
+			other.ReplyToID = nil
+			repo.uow.onRollback(func() { other.ReplyToID = old })
+		}
+		if other.ThreadRootID != nil && *other.ThreadRootID == id {
+			old := other.ThreadRootID
+			other.ThreadRootID = nil
+			repo.uow.onRollback(func() { other.ThreadRootID = old })
+		}
+	}
+	for _, attachment := range st.attachments {
+		if attachment.MessageID != nil && *attachment.MessageID == id {
+			repo.uow.attachRepo.deleteAttachment(attachment)
+		}
+	}
+	receipts, reactions := st.receipts[id], st.reactions[id]
+	delete(st.messages, id)
+	delete(st.receipts, id)
+	delete(st.reactions, id)
+	repo.uow.onRollback(func() {
+		st.messages[id] = msg
+		if receipts != nil {
+			st.receipts[id] = receipts
+		}
+		if reactions != nil {
+			st.reactions[id] = reactions
+		}
+	})
+}
+
+func (repo *MessageRepository) GetAllMessagesInChat(chatID uint64) ([]model.Message, error) {
+	return repo.filter(func(msg *model.Message) bool { return msg.ChatID == chatID }), nil
+}
+
+func (repo *MessageRepository) GetMessagesPage(chatID uint64, beforeID uint64, afterID uint64, limit int) ([]model.Message, error) {
+	msgs := repo.filter(func(msg *model.Message) bool {
+		return msg.ChatID == chatID && (afterID == 0 || msg.ID > afterID) && (afterID != 0 || beforeID == 0 || msg.ID < beforeID)
+	})
+	if len(msgs) <= limit {
+		return msgs, nil
+	}
+	if afterID != 0 {
+		return msgs[:limit], nil
+	}
+	return msgs[len(msgs)-limit:], nil
+}
+
+func (repo *MessageRepository) GetSenderID(id uint64) (uint64, error) {
+	msg, ok := repo.uow.state.messages[id]
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+	return msg.UserID, nil
+}
+
+func (repo *MessageRepository) GetChatID(id uint64) (uint64, error) {
+	msg, ok := repo.uow.state.messages[id]
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+	return msg.ChatID, nil
+}
+
+func (repo *MessageRepository) GetMessage(id uint64) (*model.Message, error) {
+	msg, ok := repo.uow.state.messages[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	view := repo.view(msg)
+	return &view, nil
+}
+
+func (repo *MessageRepository) GetThread(rootID uint64, afterID uint64, limit int) ([]model.Message, error) {
+	msgs := repo.filter(func(msg *model.Message) bool {
+		return msg.ThreadRootID != nil && *msg.ThreadRootID == rootID && msg.ID > afterID
+	})
+	return msgs[:min(limit, len(msgs))], nil
+}
+
+func (repo *MessageRepository) AddReaction(id uint64, userID uint64, emoji string) (bool, error) {
+	st := repo.uow.state
+	if _, ok := st.messages[id]; !ok {
+		return false, storage.ErrNotFound
+	}
+	reactions := st.reactions[id]
+	if slices.ContainsFunc(reactions, func(r reaction) bool { return r.userID == userID && r.emoji == emoji }) {
+		return false, nil
+	}
+	st.reactions[id] = append(slices.Clip(reactions), reaction{userID: userID, emoji: emoji, createdAt: time.Now()})
+	repo.uow.onRollback(func() { st.reactions[id] = reactions })
+	return true, nil
+}
+
+func (repo *MessageRepository) RemoveReaction(id uint64, userID uint64, emoji string) error {
+	st := repo.uow.state
+	reactions := st.reactions[id]
+	i := slices.IndexFunc(reactions, func(r reaction) bool { return r.userID == userID && r.emoji == emoji })
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+	st.reactions[id] = slices.Delete(slices.Clone(reactions), i, i+1)
+	repo.uow.onRollback(func() { st.reactions[id] = reactions })
+	return nil
+}
+
+// GetReactions counts the reactions per emoji in the order each emoji was first used.
+func (repo *MessageRepository) GetReactions(ids []uint64, userID uint64) (map[uint64][]model.ReactionCount, error) {
+	counts := make(map[uint64][]model.ReactionCount)
+	for _, id := range ids {
+		for _, r := range repo.uow.state.reactions[id] {
+			i := slices.IndexFunc(counts[id], func(c model.ReactionCount) bool { return c.Emoji == r.emoji })
+			if i < 0 {
+				counts[id] = append(counts[id], model.ReactionCount{Emoji: r.emoji})
+				i = len(counts[id]) - 1
+			}
+			counts[id][i].Count++
+			counts[id][i].Me = counts[id][i].Me || r.userID == userID
+		}
+	}
+	return counts, nil
+}
+
+// SearchMessages matches messages holding every word of the query, ignoring case. It
+// stands in for the full text search of Postgres, the snippet is the whole escaped
+// message with the words marked.
+func (repo *MessageRepository) SearchMessages(userID uint64, search *model.MessageSearch, limit int) ([]model.SearchResult, error) {
+	words := strings.Fields(strings.ToLower(search.Query))
+	msgs := repo.filter(func(msg *model.Message) bool {
+		if _, ok := repo.uow.state.members[msg.ChatID][userID]; !ok {
+			return false
+		}
+		if (search.ChatID != 0 && msg.ChatID != search.ChatID) || (search.SenderID != 0 && msg.UserID != search.SenderID) ||
+			(search.From != nil && msg.CreatedAt.Before(*search.From)) || (search.To != nil && !msg.CreatedAt.Before(*search.To)) ||
+			(search.BeforeID != 0 && msg.ID >= search.BeforeID) {
+			return false
+		}
+		text := strings.ToLower(msg.Message)
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				return false
+			}
+		}
+		return len(words) > 0
+	})
+	slices.Reverse(msgs)
+
+	results := make([]model.SearchResult, 0, min(limit, len(msgs)))
+	for _, msg := range msgs[:min(limit, len(msgs))] {
+		snippet := html.EscapeString(msg.Message)
+		for _, word := range words {
+			snippet = markWord(snippet, html.EscapeString(word))
+		}
+		results = append(results, model.SearchResult{Message: msg, Snippet: snippet})
+	}
+	return results, nil
+}
+
+func markWord(text string, word string) string {
+	var b strings.Builder
+	lower := strings.ToLower(text)
+	for {
+		i := strings.Index(lower, word)
+		if i < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		b.WriteString(text[:i] + "<mark>" + text[i:i+len(word)] + "</mark>")
+		text, lower = text[i+len(word):], lower[i+len(word):]
+	}
+}
+
+func (repo *MessageRepository) AckMessages(chatID uint64, userID uint64, ids []uint64, status model.ReceiptStatus) ([]uint64, error) {
+	st := repo.uow.state
+	acked := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		msg, ok := st.messages[id]
+		if !ok || msg.ChatID != chatID || msg.UserID == userID {
+			continue
+		}
+		receipts, ok := st.receipts[id]
+		if !ok {
+			receipts = make(map[uint64]*model.MessageReceipt)
+			st.receipts[id] = receipts
+			repo.uow.onRollback(func() { delete(st.receipts, id) })
+		}
+		now := time.Now()
+		receipt, ok := receipts[userID]
+		switch {
+		case !ok:
+			receipt = &model.MessageReceipt{MessageID: id, UserID: userID, DeliveredAt: now}
+			if status == model.Read {
+				receipt.ReadAt = &now
+			}
+			receipts[userID] = receipt
+			repo.uow.onRollback(func() { delete(receipts, userID) })
+		case status == model.Read && receipt.ReadAt == nil:
+			receipt.ReadAt = &now
+			repo.uow.onRollback(func() { receipt.ReadAt = nil })
+		default:
+			continue
+		}
+		if !slices.Contains(acked, id) {
+			acked = append(acked, id)
+		}
+	}
+	slices.Sort(acked)
+	return acked, nil
+}
+
+func (repo *MessageRepository) GetReceipts(id uint64) ([]model.MessageReceipt, error) {
+	receipts := make([]model.MessageReceipt, 0)
+	for _, receipt := range repo.uow.state.receipts[id] {
+		receipts = append(receipts, *receipt)
+	}
+	// read receipts first, like NULLS LAST on read_at
+	slices.SortFunc(receipts, func(a, b model.MessageReceipt) int {
+		switch {
+		case a.ReadAt != nil && b.ReadAt == nil:
+			return -1
+		case a.ReadAt == nil && b.ReadAt != nil:
+			return 1
+		case a.ReadAt != nil && !a.ReadAt.Equal(*b.ReadAt):
+			return a.ReadAt.Compare(*b.ReadAt)
+		}
+		return a.DeliveredAt.Compare(b.DeliveredAt)
+	})
+	return receipts, nil
+}
@@ -0,0 +1,86 @@
+// Package memory is a storage.Storage kept in process memory, for tests and local
+// runs without Postgres. Units of work run one at a time and are rolled back from an
+// undo log, deletes cascade like the foreign keys of the migrations do.
+package memory
+
+import (
+	"sync"
+	"time"
+	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
+)
+
+type member struct {
+	role          model.ChatRole
+	lastRead      uint64
+	lastDelivered uint64
+}
+
+type reaction struct {
+	userID    uint64
+	emoji     string
+	createdAt time.Time
+}
+
+type user struct {
+	name     string
+	lastSeen *time.Time
+}
+
+type state struct {
+	lastChatID       uint64
+	lastMessageID    uint64
+	lastAttachmentID uint64
+
+	chats      map[uint64]*model.Chat
+	directKeys map[string]uint64
+	// members is keyed by chat id, then user id.
+	members  map[uint64]map[uint64]*member
+	messages map[uint64]*model.Message
+	// receipts and reactions are keyed by message id.
+	receipts    map[uint64]map[uint64]*model.MessageReceipt
+	reactions   map[uint64][]reaction
+	attachments map[uint64]*model.Attachment
+	// deletedBlobs holds the storage keys of deleted attachments, like the deleted_blobs
+	// table filled by a trigger.
+	deletedBlobs []string
+	// users only holds names and last seen times, every user id is taken to exist.
+	users map[uint64]*user
+}
+
+type Storage struct {
+	mu    sync.Mutex
+	state state
+}
+
+func NewStorage() *Storage {
+	return &Storage{state: state{
+		chats:       make(map[uint64]*model.Chat),
+		directKeys:  make(map[string]uint64),
+		members:     make(map[uint64]map[uint64]*member),
+		messages:    make(map[uint64]*model.Message),
+		receipts:    make(map[uint64]map[uint64]*model.MessageReceipt),
+		reactions:   make(map[uint64][]reaction),
+		attachments: make(map[uint64]*model.Attachment),
+		users:       make(map[uint64]*user),
+	}}
+}
+
+// AddUser sets the name GetChatInfo reports for the user.
+func (s *Storage) AddUser(id uint64, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.state.users[id]; ok {
+		u.name = name
+		return
+	}
+	s.state.users[id] = &user{name: name}
+}
+
+// CreateUnitOfWork blocks until the running unit of work is committed or rolled back.
+func (s *Storage) CreateUnitOfWork() (storage.UnitOfWork, error) {
+	s.mu.Lock()
+	return newUnitOfWork(s), nil
+}
+
+func (s *Storage) Close() {}
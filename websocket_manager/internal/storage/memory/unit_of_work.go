@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"errors"
+	"websocket_manager/internal/storage"
+)
+
+var errDone = errors.New("unit of work already committed or rolled back")
+
+type UnitOfWork struct {
+	storage *Storage
+	state   *state
+	// undo holds the inverse of every change made, run backwards on rollback.
+	undo        []func()
+	done        bool
+	chatRepo    ChatRepository
+	messageRepo MessageRepository
+	userRepo    UserRepository
+	attachRepo  AttachmentRepository
+}
+
+func newUnitOfWork(s *Storage) *UnitOfWork {
+	u := &UnitOfWork{storage: s, state: &s.state}
+	u.chatRepo = ChatRepository{uow: u}
+	u.messageRepo = MessageRepository{uow: u}
+	u.userRepo = UserRepository{uow: u}
+	u.attachRepo = AttachmentRepository{uow: u}
+	return u
+}
+
+func (u *UnitOfWork) ChatRepository() storage.ChatRepository {
+	return &u.chatRepo
+}
+
+func (u *UnitOfWork) MessageRepository() storage.MessageRepository {
+	return &u.messageRepo
+}
+
+func (u *UnitOfWork) UserRepository() storage.UserRepository {
+	return &u.userRepo
+}
+
+func (u *UnitOfWork) AttachmentRepository() storage.AttachmentRepository {
+	return &u.attachRepo
+}
+
+func (u *UnitOfWork) Commit() error {
+	if u.done {
+		return errDone
+	}
+	u.done = true
+	u.undo = nil
+	u.storage.mu.Unlock()
+	return nil
+}
+
+func (u *UnitOfWork) Rollback() error {
+	if u.done {
+		return errDone
+	}
+	u.done = true
+	for i := len(u.undo) - 1; i >= 0; i-- {
+		u.undo[i]()
+	}
+	u.undo = nil
+	u.storage.mu.Unlock()
+	return nil
+}
+
+func (u *UnitOfWork) onRollback(f func()) {
+	u.undo = append(u.undo, f)
+}
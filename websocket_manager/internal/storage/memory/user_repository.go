@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"time"
+)
+
+type UserRepository struct {
+	uow *UnitOfWork
+}
+
+func (repo *UserRepository) GetContactIDs(id uint64) ([]uint64, error) {
+	seen := make(map[uint64]bool)
+	ids := make([]uint64, 0)
+	for _, members := range repo.uow.state.members {
+		if _, ok := members[id]; !ok {
+			continue
+		}
+		for other := range members {
+			if other != id && !seen[other] {
+				seen[other] = true
+				ids = append(ids, other)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (repo *UserRepository) UpdateLastSeen(id uint64, at time.Time) error {
+	users := repo.uow.state.users
+	u, ok := users[id]
+	if !ok {
+		u = &user{}
+		users[id] = u
+		repo.uow.onRollback(func() { delete(users, id) })
+	}
+	old := u.lastSeen
+	u.lastSeen = &at
+	repo.uow.onRollback(func() { u.lastSeen = old })
+	return nil
+}
+
+func (repo *UserRepository) GetLastSeen(ids []uint64) (map[uint64]time.Time, error) {
+	lastSeen := make(map[uint64]time.Time, len(ids))
+	for _, id := range ids {
+		if u, ok := repo.uow.state.users[id]; ok && u.lastSeen != nil {
+			lastSeen[id] = *u.lastSeen
+		}
+	}
+	return lastSeen, nil
+}
@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"websocket_manager/internal/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type AttachmentRepository struct {
+	tx     pgx.Tx
+	logger *slog.Logger
+}
+
+const attachmentColumns = "id, user_id, message_id, storage_key, filename, content_type, size, created_at"
+
+func scanAttachment(row pgx.Row, attachment *model.Attachment) error {
+	return row.Scan(&attachment.ID, &attachment.UserID, &attachment.MessageID, &attachment.StorageKey, &attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.CreatedAt)
+}
+
+func (repo *AttachmentRepository) CreateAttachment(attachment *model.Attachment) error {
+	err := repo.tx.QueryRow(context.Background(), "INSERT INTO attachments (user_id, storage_key, filename, content_type, size) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+		attachment.UserID, attachment.StorageKey, attachment.Filename, attachment.ContentType, attachment.Size).Scan(&attachment.ID, &attachment.CreatedAt)
+	if err != nil {
+		repo.logger.Error("failed to create attachment", "error", err)
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (repo *AttachmentRepository) AttachToMessage(ids []uint64, userID uint64, messageID uint64) ([]model.Attachment, error) {
+	rows, err := repo.tx.Query(context.Background(), "UPDATE attachments SET message_id = $1 WHERE id = ANY($2) AND user_id = $3 AND message_id IS NULL RETURNING "+attachmentColumns, messageID, ids, userID)
+	if err != nil {
+		repo.logger.Error("failed to attach to message", "error", err)
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	attachments := make([]model.Attachment, 0, len(ids))
+	for rows.Next() {
+		var attachment model.Attachment
+		if err := scanAttachment(rows, &attachment); err != nil {
+			repo.logger.Error("failed to scan attachment", "error", err)
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read attachments", "error", err)
+		return nil, mapError(err)
+	}
+
+	return attachments, nil
+}
+
+func (repo *AttachmentRepository) GetAttachment(id uint64) (*model.Attachment, uint64, error) {
+	var attachment model.Attachment
+	var chatID *uint64
+	err := repo.tx.QueryRow(context.Background(), `SELECT a.id, a.user_id, a.message_id, a.storage_key, a.filename, a.content_type, a.size, a.created_at, m.chat_id
+		FROM attachments a LEFT JOIN messages m ON m.id = a.message_id WHERE a.id = $1`, id).Scan(
+		&attachment.ID, &attachment.UserID, &attachment.MessageID, &attachment.StorageKey, &attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.CreatedAt, &chatID)
+	if err != nil {
+		repo.logger.Error("failed to get attachment", "error", err)
+		return nil, 0, mapError(err)
+	}
+	if chatID == nil {
+		return &attachment, 0, nil
+	}
+
+	return &attachment, *chatID, nil
+}
+
+func (repo *AttachmentRepository) GetByMessages(messageIDs []uint64) (map[uint64][]model.Attachment, error) {
+	rows, err := repo.tx.Query(context.Background(), "SELECT "+attachmentColumns+" FROM attachments WHERE message_id = ANY($1) ORDER BY id", messageIDs)
+	if err != nil {
+		repo.logger.Error("failed to get attachments", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make(map[uint64][]model.Attachment)
+	for rows.Next() {
+		var attachment model.Attachment
+		if err := scanAttachment(rows, &attachment); err != nil {
+			repo.logger.Error("failed to scan attachment", "error", err)
+			return nil, err
+		}
+		attachments[*attachment.MessageID] = append(attachments[*attachment.MessageID], attachment)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read attachments", "error", err)
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+func (repo *AttachmentRepository) DeleteUnlinked(before time.Time) (int64, error) {
+	tag, err := repo.tx.Exec(context.Background(), "DELETE FROM attachments WHERE message_id IS NULL AND created_at < $1", before)
+	if err != nil {
+		repo.logger.Error("failed to delete unlinked attachments", "error", err)
+		return 0, mapError(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (repo *AttachmentRepository) GetDeletedBlobs(limit int) ([]string, error) {
+	rows, err := repo.tx.Query(context.Background(), "SELECT storage_key FROM deleted_blobs ORDER BY deleted_at LIMIT $1", limit)
+	if err != nil {
+		repo.logger.Error("failed to get deleted blobs", "error", err)
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			repo.logger.Error("failed to scan deleted blob", "error", err)
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read deleted blobs", "error", err)
+		return nil, mapError(err)
+	}
+
+	return keys, nil
+}
+
+func (repo *AttachmentRepository) ForgetDeletedBlobs(keys []string) error {
+	_, err := repo.tx.Exec(context.Background(), "DELETE FROM deleted_blobs WHERE storage_key = ANY($1)", keys)
+	if err != nil {
+		repo.logger.Error("failed to forget deleted blobs", "error", err)
+		return mapError(err)
+	}
+
+	return nil
+}
@@ -2,8 +2,11 @@ package postgres
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
 
 	"github.com/jackc/pgx/v5"
 )
@@ -14,16 +17,17 @@ type ChatRepository struct {
 }
 
 func (repo *ChatRepository) GetAllUserChats(id uint64) ([]model.Chat, error) {
-	rows, err := repo.tx.Query(context.Background(), "SELECT chat_id, name, creator_id FROM chat_users JOIN chats ON chats.id = chat_id WHERE user_id = $1", id)
+	rows, err := repo.tx.Query(context.Background(), "SELECT chat_id, name, type, creator_id FROM chat_users JOIN chats ON chats.id = chat_id WHERE user_id = $1", id)
 	if err != nil {
 		repo.logger.Error("failed to get chat ids", "error", err)
 		return nil, err
 	}
+	defer rows.Close()
 
 	chats := make([]model.Chat, 0, rows.CommandTag().RowsAffected())
 	for rows.Next() {
 		var chat model.Chat
-		err = rows.Scan(&chat.ID, &chat.Name, &chat.CreatorID)
+		err = rows.Scan(&chat.ID, &chat.Name, &chat.Type, &chat.CreatorID)
 		if err != nil {
 			repo.logger.Error("failed to scan chat", "error", err)
 			return nil, err
@@ -31,60 +35,127 @@ func (repo *ChatRepository) GetAllUserChats(id uint64) ([]model.Chat, error) {
 
 		chats = append(chats, chat)
 	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read chats", "error", err)
+		return nil, err
+	}
 
 	return chats, nil
 }
 
 func (repo *ChatRepository) CreateChat(chat *model.Chat) error {
+	chat.Type = model.Group
 	err := repo.tx.QueryRow(context.Background(), "INSERT INTO chats (name, creator_id) VALUES ($1, $2) RETURNING id", chat.Name, chat.CreatorID).Scan(&chat.ID)
 	if err != nil {
 		repo.logger.Error("failed to create chat", "error", err)
+		return mapError(err)
 	}
 
-	return err
+	return nil
+}
+
+// OpenDirectChat returns the direct chat of the two users, creating it with both of
+// them as members when there is none yet. created tells which one happened.
+func (repo *ChatRepository) OpenDirectChat(userID uint64, peerID uint64) (*model.Chat, bool, error) {
+	key := fmt.Sprintf("%d:%d", min(userID, peerID), max(userID, peerID))
+	chat := &model.Chat{Type: model.Direct, CreatorID: userID}
+	err := repo.tx.QueryRow(context.Background(), `INSERT INTO chats (name, creator_id, type, direct_key) VALUES ('', $1, $2, $3)
+		ON CONFLICT (direct_key) WHERE direct_key IS NOT NULL DO NOTHING RETURNING id`, userID, model.Direct, key).Scan(&chat.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = repo.tx.QueryRow(context.Background(), "SELECT id, creator_id FROM chats WHERE direct_key = $1", key).Scan(&chat.ID, &chat.CreatorID)
+		if err != nil {
+			repo.logger.Error("failed to get direct chat", "error", err)
+			return nil, false, mapError(err)
+		}
+		_, err = repo.tx.Exec(context.Background(), "INSERT INTO chat_users (chat_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", chat.ID, userID, model.Member)
+		if err != nil {
+			repo.logger.Error("failed to restore direct chat member", "error", err)
+			return nil, false, mapError(err)
+		}
+		return chat, false, nil
+	}
+	if err != nil {
+		repo.logger.Error("failed to create direct chat", "error", err)
+		return nil, false, mapError(err)
+	}
+	_, err = repo.tx.Exec(context.Background(), "INSERT INTO chat_users (chat_id, user_id, role) VALUES ($1, $2, $4), ($1, $3, $4)", chat.ID, userID, peerID, model.Member)
+	if err != nil {
+		repo.logger.Error("failed to add direct chat members", "error", err)
+		return nil, false, mapError(err)
+	}
+
+	return chat, true, nil
+}
+
+func (repo *ChatRepository) GetChatType(id uint64) (model.ChatType, error) {
+	var chatType model.ChatType
+	err := repo.tx.QueryRow(context.Background(), "SELECT type FROM chats WHERE id = $1", id).Scan(&chatType)
+	if err != nil {
+		repo.logger.Error("failed to get chat type", "error", err)
+		return "", mapError(err)
+	}
+	return chatType, nil
 }
 
 func (repo *ChatRepository) UpdateChat(chat *model.Chat) error {
-	_, err := repo.tx.Exec(context.Background(), "UPDATE chats SET name = $1, updated_at = now() WHERE id = $2", chat.Name, chat.ID)
+	tag, err := repo.tx.Exec(context.Background(), "UPDATE chats SET name = $1, updated_at = now() WHERE id = $2", chat.Name, chat.ID)
 	if err != nil {
 		repo.logger.Error("failed to update chat", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
 	}
 
-	return err
+	return nil
 }
 
 func (repo *ChatRepository) DeleteChat(id uint64) error {
-	_, err := repo.tx.Exec(context.Background(), "DELETE FROM chats WHERE id = $1", id)
+	tag, err := repo.tx.Exec(context.Background(), "DELETE FROM chats WHERE id = $1", id)
 	if err != nil {
 		repo.logger.Error("failed to delete chat", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
 	}
 
-	return err
+	return nil
 }
 
 func (repo *ChatRepository) AddUserToChat(chatUsers *model.ChatUsers) error {
-	_, err := repo.tx.Exec(context.Background(), "INSERT INTO chat_users (chat_id, user_id) VALUES ($1, $2)", chatUsers.ChatID, chatUsers.UserID)
+	if chatUsers.Role == "" {
+		chatUsers.Role = model.Member
+	}
+	_, err := repo.tx.Exec(context.Background(), "INSERT INTO chat_users (chat_id, user_id, role) VALUES ($1, $2, $3)", chatUsers.ChatID, chatUsers.UserID, chatUsers.Role)
 	if err != nil {
 		repo.logger.Error("failed to add user to chat", "error", err)
+		return mapError(err)
 	}
 
-	return err
+	return nil
 }
 
 func (repo *ChatRepository) DeleteUserFromChat(chatUsers *model.ChatUsers) error {
-	_, err := repo.tx.Exec(context.Background(), "DELETE FROM chat_users WHERE chat_id = $1 AND user_id = $2", chatUsers.ChatID, chatUsers.UserID)
+	tag, err := repo.tx.Exec(context.Background(), "DELETE FROM chat_users WHERE chat_id = $1 AND user_id = $2", chatUsers.ChatID, chatUsers.UserID)
 	if err != nil {
 		repo.logger.Error("failed to delete user from chat", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
 	}
 
-	return err
+	return nil
 }
 
 func (repo *ChatRepository) GetAllUsersIDInChat(id uint64) ([]uint64, error) {
 	rows, err := repo.tx.Query(context.Background(), "SELECT user_id FROM chat_users WHERE chat_id = $1", id)
 	if err != nil {
 		repo.logger.Error("failed to get all users in chat", "error", err)
+		return nil, err
 	}
+	defer rows.Close()
 
 	ids := make([]uint64, 0, rows.CommandTag().RowsAffected())
 	for rows.Next() {
@@ -95,42 +166,158 @@ func (repo *ChatRepository) GetAllUsersIDInChat(id uint64) ([]uint64, error) {
 		}
 		ids = append(ids, id)
 	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read users in chat", "error", err)
+		return nil, err
+	}
 
 	return ids, nil
 }
 
-func (repo *ChatRepository) GetOwnerID(id uint64) (uint64, error) {
-	var ownerId uint64
-	err := repo.tx.QueryRow(context.Background(), "SELECT creator_id FROM chats WHERE id = $1", id).Scan(&ownerId)
+func (repo *ChatRepository) IsMember(chatID uint64, userID uint64) (bool, error) {
+	var isMember bool
+	err := repo.tx.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM chat_users WHERE chat_id = $1 AND user_id = $2)", chatID, userID).Scan(&isMember)
+	if err != nil {
+		repo.logger.Error("failed to check chat membership", "error", err)
+		return false, mapError(err)
+	}
+	return isMember, nil
+}
+
+// GetRole returns storage.ErrNotFound when the user is not a member of the chat.
+func (repo *ChatRepository) GetRole(chatID uint64, userID uint64) (model.ChatRole, error) {
+	var role model.ChatRole
+	err := repo.tx.QueryRow(context.Background(), "SELECT role FROM chat_users WHERE chat_id = $1 AND user_id = $2", chatID, userID).Scan(&role)
 	if err != nil {
-		repo.logger.Error("failed to get owner id", "error", err)
-		return 0, err
+		repo.logger.Error("failed to get role", "error", err)
+		return "", mapError(err)
 	}
-	return ownerId, nil
+	return role, nil
+}
+
+func (repo *ChatRepository) SetRole(chatUsers *model.ChatUsers) error {
+	tag, err := repo.tx.Exec(context.Background(), "UPDATE chat_users SET role = $1 WHERE chat_id = $2 AND user_id = $3", chatUsers.Role, chatUsers.ChatID, chatUsers.UserID)
+	if err != nil {
+		repo.logger.Error("failed to set role", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// TransferOwnership makes toUserID the owner of the chat and demotes the previous owner to admin.
+func (repo *ChatRepository) TransferOwnership(chatID uint64, fromUserID uint64, toUserID uint64) error {
+	// the previous owner is demoted first, a chat can't have two owners
+	_, err := repo.tx.Exec(context.Background(), "UPDATE chat_users SET role = $1 WHERE chat_id = $2 AND user_id = $3", model.Admin, chatID, fromUserID)
+	if err != nil {
+		repo.logger.Error("failed to demote owner", "error", err)
+		return mapError(err)
+	}
+	tag, err := repo.tx.Exec(context.Background(), "UPDATE chat_users SET role = $1 WHERE chat_id = $2 AND user_id = $3", model.Owner, chatID, toUserID)
+	if err != nil {
+		repo.logger.Error("failed to promote owner", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	_, err = repo.tx.Exec(context.Background(), "UPDATE chats SET creator_id = $1, updated_at = now() WHERE id = $2", toUserID, chatID)
+	if err != nil {
+		repo.logger.Error("failed to update chat owner", "error", err)
+		return mapError(err)
+	}
+
+	return nil
 }
 
 func (repo *ChatRepository) GetChatInfo(id uint64) (*model.Chat, []model.User, error) {
 	chat := &model.Chat{ID: id}
-	err := repo.tx.QueryRow(context.Background(), "SELECT name, creator_id FROM chats WHERE id = $1", id).Scan(&chat.Name, &chat.CreatorID)
+	err := repo.tx.QueryRow(context.Background(), "SELECT name, type, creator_id FROM chats WHERE id = $1", id).Scan(&chat.Name, &chat.Type, &chat.CreatorID)
 	if err != nil {
 		repo.logger.Error("failed to get chat info", "error", err)
-		return nil, nil, err
+		return nil, nil, mapError(err)
 	}
 
-	rows, err := repo.tx.Query(context.Background(), "SELECT user_id, username FROM chat_users JOIN users ON user_id = users.id WHERE chat_id = $1", id)
+	rows, err := repo.tx.Query(context.Background(), "SELECT user_id, username, role FROM chat_users JOIN users ON user_id = users.id WHERE chat_id = $1", id)
 	if err != nil {
 		repo.logger.Error("failed to get chat info", "error", err)
 		return nil, nil, err
 	}
+	defer rows.Close()
 	users := make([]model.User, 0, rows.CommandTag().RowsAffected())
 	for rows.Next() {
 		var user model.User
-		if err := rows.Scan(&user.Id, &user.Name); err != nil {
+		if err := rows.Scan(&user.Id, &user.Name, &user.Role); err != nil {
 			repo.logger.Error("failed to scan user id", "error", err)
 			return nil, nil, err
 		}
 		users = append(users, user)
 	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read chat members", "error", err)
+		return nil, nil, err
+	}
 
 	return chat, users, nil
 }
+
+// GetCursors returns the cursors of the user in every chat, unread messages of the
+// user's own are not counted.
+func (repo *ChatRepository) GetCursors(userID uint64) ([]model.ChatCursor, error) {
+	rows, err := repo.tx.Query(context.Background(), `SELECT cu.chat_id, cu.last_read_message_id, cu.last_delivered_message_id,
+		COALESCE((SELECT MAX(m.id) FROM messages m WHERE m.chat_id = cu.chat_id), 0),
+		(SELECT COUNT(*) FROM messages m WHERE m.chat_id = cu.chat_id AND m.id > cu.last_read_message_id AND m.user_id <> cu.user_id)
+		FROM chat_users cu WHERE cu.user_id = $1 ORDER BY cu.chat_id`, userID)
+	if err != nil {
+		repo.logger.Error("failed to get cursors", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	cursors := make([]model.ChatCursor, 0)
+	for rows.Next() {
+		var cursor model.ChatCursor
+		if err := rows.Scan(&cursor.ChatID, &cursor.LastReadMessageID, &cursor.LastDeliveredMessageID, &cursor.LastMessageID, &cursor.UnreadCount); err != nil {
+			repo.logger.Error("failed to scan cursor", "error", err)
+			return nil, err
+		}
+		cursors = append(cursors, cursor)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read cursors", "error", err)
+		return nil, err
+	}
+
+	return cursors, nil
+}
+
+// MarkDelivered moves the delivered cursor forward, it never goes back.
+func (repo *ChatRepository) MarkDelivered(chatID uint64, userID uint64, messageID uint64) error {
+	tag, err := repo.tx.Exec(context.Background(), "UPDATE chat_users SET last_delivered_message_id = GREATEST(last_delivered_message_id, $1) WHERE chat_id = $2 AND user_id = $3", messageID, chatID, userID)
+	if err != nil {
+		repo.logger.Error("failed to mark delivered", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// MarkRead moves the read cursor forward, a read message is delivered as well.
+func (repo *ChatRepository) MarkRead(chatID uint64, userID uint64, messageID uint64) error {
+	tag, err := repo.tx.Exec(context.Background(), "UPDATE chat_users SET last_read_message_id = GREATEST(last_read_message_id, $1), last_delivered_message_id = GREATEST(last_delivered_message_id, $1) WHERE chat_id = $2 AND user_id = $3", messageID, chatID, userID)
+	if err != nil {
+		repo.logger.Error("failed to mark read", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
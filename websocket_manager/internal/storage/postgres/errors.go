@@ -0,0 +1,32 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"websocket_manager/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+)
+
+// mapError wraps database errors into the storage errors handlers can tell apart.
+func mapError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %w", storage.ErrNotFound, err)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case uniqueViolation:
+			return fmt.Errorf("%w: %w", storage.ErrConflict, err)
+		case foreignKeyViolation:
+			return fmt.Errorf("%w: %w", storage.ErrNotFound, err)
+		}
+	}
+	return err
+}
@@ -3,7 +3,9 @@ package postgres
 import (
 	"context"
 	"log/slog"
+	"slices"
 	"websocket_manager/internal/model"
+	"websocket_manager/internal/storage"
 
 	"github.com/jackc/pgx/v5"
 )
@@ -13,49 +15,121 @@ type MessageRepository struct {
 	logger *slog.Logger
 }
 
+// messageColumns are scanned by scanMessage, reply_count counts the thread a message starts.
+const messageColumns = `id, user_id, message, reply_to_id, thread_root_id,
+	(SELECT COUNT(*) FROM messages r WHERE r.thread_root_id = messages.id), created_at, updated_at`
+
+func scanMessage(row pgx.Row, msg *model.Message) error {
+	return row.Scan(&msg.ID, &msg.UserID, &msg.Message, &msg.ReplyToID, &msg.ThreadRootID, &msg.ReplyCount, &msg.CreatedAt, &msg.UpdatedAt)
+}
+
+// AddMessage stores the message. A reply must answer a message of the same chat and
+// joins the thread of that message, or starts one.
 func (repo *MessageRepository) AddMessage(msg *model.Message) error {
-	err := repo.tx.QueryRow(context.Background(), "INSERT INTO messages (chat_id, user_id, message) VALUES ($1, $2, $3) RETURNING id", msg.ChatID, msg.UserID, msg.Message).Scan(&msg.ID)
+	var err error
+	if msg.ReplyToID == nil {
+		err = repo.tx.QueryRow(context.Background(), "INSERT INTO messages (chat_id, user_id, message) VALUES ($1, $2, $3) RETURNING id", msg.ChatID, msg.UserID, msg.Message).Scan(&msg.ID)
+	} else {
+		err = repo.tx.QueryRow(context.Background(), `INSERT INTO messages (chat_id, user_id, message, reply_to_id, thread_root_id)
+			SELECT $1, $2, $3, p.id, COALESCE(p.thread_root_id, p.id) FROM messages p WHERE p.id = $4 AND p.chat_id = $1
+			RETURNING id, thread_root_id`, msg.ChatID, msg.UserID, msg.Message, *msg.ReplyToID).Scan(&msg.ID, &msg.ThreadRootID)
+	}
 	if err != nil {
 		repo.logger.Error("failed to send message", "error", err)
+		return mapError(err)
 	}
 
-	return err
+	return nil
 }
 
 func (repo *MessageRepository) UpdateMessage(msg *model.Message) error {
-	_, err := repo.tx.Exec(context.Background(), "UPDATE messages SET message = $1, updated_at = now() WHERE id = $2", msg.Message, msg.ID)
+	tag, err := repo.tx.Exec(context.Background(), "UPDATE messages SET message = $1, updated_at = now() WHERE id = $2", msg.Message, msg.ID)
 	if err != nil {
 		repo.logger.Error("failed to update message", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
 	}
 
-	return err
+	return nil
 }
 
 func (repo *MessageRepository) DeleteMessage(id uint64) error {
-	_, err := repo.tx.Exec(context.Background(), "DELETE FROM messages WHERE id = $1", id)
+	tag, err := repo.tx.Exec(context.Background(), "DELETE FROM messages WHERE id = $1", id)
 	if err != nil {
 		repo.logger.Error("failed to delete message", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
 	}
 
-	return err
+	return nil
 }
 
 func (repo *MessageRepository) GetAllMessagesInChat(chatID uint64) ([]model.Message, error) {
-	rows, err := repo.tx.Query(context.Background(), "SELECT id, user_id, message, created_at, updated_at FROM messages WHERE chat_id = $1", chatID)
+	rows, err := repo.tx.Query(context.Background(), "SELECT "+messageColumns+" FROM messages WHERE chat_id = $1 ORDER BY id", chatID)
 	if err != nil {
 		repo.logger.Error("failed to get all messages in chat", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := make([]model.Message, 0)
+	for rows.Next() {
+		msg := model.Message{ChatID: chatID}
+		if err := scanMessage(rows, &msg); err != nil {
+			repo.logger.Error("failed to scan message", "error", err)
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read messages in chat", "error", err)
+		return nil, err
 	}
 
-	msgs := make([]model.Message, rows.CommandTag().RowsAffected())
+	return msgs, nil
+}
+
+// GetMessagesPage returns up to limit messages of the chat ordered by id. With beforeID set
+// it returns the newest messages older than beforeID, with afterID set the oldest messages
+// newer than afterID, and with neither the latest messages of the chat.
+func (repo *MessageRepository) GetMessagesPage(chatID uint64, beforeID uint64, afterID uint64, limit int) ([]model.Message, error) {
+	var rows pgx.Rows
+	var err error
+	switch {
+	case afterID != 0:
+		rows, err = repo.tx.Query(context.Background(), "SELECT "+messageColumns+" FROM messages WHERE chat_id = $1 AND id > $2 ORDER BY id LIMIT $3", chatID, afterID, limit)
+	case beforeID != 0:
+		rows, err = repo.tx.Query(context.Background(), "SELECT "+messageColumns+" FROM messages WHERE chat_id = $1 AND id < $2 ORDER BY id DESC LIMIT $3", chatID, beforeID, limit)
+	default:
+		rows, err = repo.tx.Query(context.Background(), "SELECT "+messageColumns+" FROM messages WHERE chat_id = $1 ORDER BY id DESC LIMIT $2", chatID, limit)
+	}
+	if err != nil {
+		repo.logger.Error("failed to get messages page", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := make([]model.Message, 0, limit)
 	for rows.Next() {
 		msg := model.Message{ChatID: chatID}
-		if err := rows.Scan(&msg.ID, &msg.UserID, &msg.Message, &msg.CreatedAt, &msg.UpdatedAt); err != nil {
+		if err := scanMessage(rows, &msg); err != nil {
 			repo.logger.Error("failed to scan message", "error", err)
 			return nil, err
 		}
 		msgs = append(msgs, msg)
 	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read messages page", "error", err)
+		return nil, err
+	}
 
+	if afterID == 0 {
+		slices.Reverse(msgs)
+	}
 	return msgs, nil
 }
 
@@ -64,6 +138,203 @@ func (repo *MessageRepository) GetSenderID(id uint64) (uint64, error) {
 	err := repo.tx.QueryRow(context.Background(), "SELECT user_id FROM messages WHERE id = $1", id).Scan(&senderID)
 	if err != nil {
 		repo.logger.Error("failed to get sender id", "error", err)
+		return 0, mapError(err)
 	}
 	return senderID, nil
 }
+
+func (repo *MessageRepository) GetChatID(id uint64) (uint64, error) {
+	var chatID uint64
+	err := repo.tx.QueryRow(context.Background(), "SELECT chat_id FROM messages WHERE id = $1", id).Scan(&chatID)
+	if err != nil {
+		repo.logger.Error("failed to get chat id", "error", err)
+		return 0, mapError(err)
+	}
+	return chatID, nil
+}
+
+// AckMessages stores the receipts of the user for the messages of the chat and returns
+// the ids whose status changed. Messages of other chats and the user's own are skipped.
+func (repo *MessageRepository) AckMessages(chatID uint64, userID uint64, ids []uint64, status model.ReceiptStatus) ([]uint64, error) {
+	query := `INSERT INTO message_receipts (message_id, user_id) SELECT id, $2 FROM messages WHERE id = ANY($1) AND chat_id = $3 AND user_id <> $2
+		ON CONFLICT (message_id, user_id) DO NOTHING RETURNING message_id`
+	if status == model.Read {
+		query = `INSERT INTO message_receipts (message_id, user_id, read_at) SELECT id, $2, now() FROM messages WHERE id = ANY($1) AND chat_id = $3 AND user_id <> $2
+			ON CONFLICT (message_id, user_id) DO UPDATE SET read_at = EXCLUDED.read_at WHERE message_receipts.read_at IS NULL RETURNING message_id`
+	}
+	rows, err := repo.tx.Query(context.Background(), query, ids, userID, chatID)
+	if err != nil {
+		repo.logger.Error("failed to ack messages", "error", err)
+		return nil, mapError(err)
+	}
+	defer rows.Close()
+
+	acked := make([]uint64, 0, len(ids))
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			repo.logger.Error("failed to scan acked message", "error", err)
+			return nil, err
+		}
+		acked = append(acked, id)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read acked messages", "error", err)
+		return nil, mapError(err)
+	}
+	slices.Sort(acked)
+
+	return acked, nil
+}
+
+func (repo *MessageRepository) GetReceipts(id uint64) ([]model.MessageReceipt, error) {
+	rows, err := repo.tx.Query(context.Background(), "SELECT message_id, user_id, delivered_at, read_at FROM message_receipts WHERE message_id = $1 ORDER BY read_at NULLS LAST, delivered_at", id)
+	if err != nil {
+		repo.logger.Error("failed to get receipts", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := make([]model.MessageReceipt, 0)
+	for rows.Next() {
+		var receipt model.MessageReceipt
+		if err := rows.Scan(&receipt.MessageID, &receipt.UserID, &receipt.DeliveredAt, &receipt.ReadAt); err != nil {
+			repo.logger.Error("failed to scan receipt", "error", err)
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read receipts", "error", err)
+		return nil, err
+	}
+
+	return receipts, nil
+}
+
+func (repo *MessageRepository) GetMessage(id uint64) (*model.Message, error) {
+	var msg model.Message
+	row := repo.tx.QueryRow(context.Background(), "SELECT chat_id, "+messageColumns+" FROM messages WHERE id = $1", id)
+	err := row.Scan(&msg.ChatID, &msg.ID, &msg.UserID, &msg.Message, &msg.ReplyToID, &msg.ThreadRootID, &msg.ReplyCount, &msg.CreatedAt, &msg.UpdatedAt)
+	if err != nil {
+		repo.logger.Error("failed to get message", "error", err)
+		return nil, mapError(err)
+	}
+	return &msg, nil
+}
+
+// GetThread returns up to limit replies of the thread newer than afterID, oldest first.
+func (repo *MessageRepository) GetThread(rootID uint64, afterID uint64, limit int) ([]model.Message, error) {
+	rows, err := repo.tx.Query(context.Background(), "SELECT chat_id, "+messageColumns+" FROM messages WHERE thread_root_id = $1 AND id > $2 ORDER BY id LIMIT $3", rootID, afterID, limit)
+	if err != nil {
+		repo.logger.Error("failed to get thread", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := make([]model.Message, 0, limit)
+	for rows.Next() {
+		var msg model.Message
+		if err := rows.Scan(&msg.ChatID, &msg.ID, &msg.UserID, &msg.Message, &msg.ReplyToID, &msg.ThreadRootID, &msg.ReplyCount, &msg.CreatedAt, &msg.UpdatedAt); err != nil {
+			repo.logger.Error("failed to scan message", "error", err)
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read thread", "error", err)
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// AddReaction is idempotent, reacting twice with the same emoji keeps one reaction.
+func (repo *MessageRepository) AddReaction(id uint64, userID uint64, emoji string) (bool, error) {
+	tag, err := repo.tx.Exec(context.Background(), "INSERT INTO message_reactions (message_id, user_id, emoji) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", id, userID, emoji)
+	if err != nil {
+		repo.logger.Error("failed to add reaction", "error", err)
+		return false, mapError(err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (repo *MessageRepository) RemoveReaction(id uint64, userID uint64, emoji string) error {
+	tag, err := repo.tx.Exec(context.Background(), "DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3", id, userID, emoji)
+	if err != nil {
+		repo.logger.Error("failed to remove reaction", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *MessageRepository) GetReactions(ids []uint64, userID uint64) (map[uint64][]model.ReactionCount, error) {
+	rows, err := repo.tx.Query(context.Background(), `SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2) FROM message_reactions
+		WHERE message_id = ANY($1) GROUP BY message_id, emoji ORDER BY message_id, MIN(created_at)`, ids, userID)
+	if err != nil {
+		repo.logger.Error("failed to get reactions", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := make(map[uint64][]model.ReactionCount)
+	for rows.Next() {
+		var id uint64
+		var reaction model.ReactionCount
+		if err := rows.Scan(&id, &reaction.Emoji, &reaction.Count, &reaction.Me); err != nil {
+			repo.logger.Error("failed to scan reaction", "error", err)
+			return nil, err
+		}
+		reactions[id] = append(reactions[id], reaction)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read reactions", "error", err)
+		return nil, err
+	}
+
+	return reactions, nil
+}
+
+func (repo *MessageRepository) SearchMessages(userID uint64, search *model.MessageSearch, limit int) ([]model.SearchResult, error) {
+	// the message is escaped before highlighting, so the snippet only holds our <mark> tags
+	rows, err := repo.tx.Query(context.Background(), `SELECT chat_id, `+messageColumns+`,
+		ts_headline('simple', replace(replace(replace(message, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), q,
+			'StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2')
+		FROM messages, websearch_to_tsquery('simple', $2) q
+		WHERE search_vector @@ q
+			AND chat_id IN (SELECT chat_id FROM chat_users WHERE user_id = $1)
+			AND ($3::bigint = 0 OR chat_id = $3)
+			AND ($4::bigint = 0 OR user_id = $4)
+			AND ($5::timestamptz IS NULL OR created_at >= $5)
+			AND ($6::timestamptz IS NULL OR created_at < $6)
+			AND ($7::bigint = 0 OR id < $7)
+		ORDER BY id DESC LIMIT $8`,
+		userID, search.Query, search.ChatID, search.SenderID, search.From, search.To, search.BeforeID, limit)
+	if err != nil {
+		repo.logger.Error("failed to search messages", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]model.SearchResult, 0, limit)
+	for rows.Next() {
+		var result model.SearchResult
+		msg := &result.Message
+		if err := rows.Scan(&msg.ChatID, &msg.ID, &msg.UserID, &msg.Message, &msg.ReplyToID, &msg.ThreadRootID, &msg.ReplyCount, &msg.CreatedAt, &msg.UpdatedAt, &result.Snippet); err != nil {
+			repo.logger.Error("failed to scan search result", "error", err)
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read search results", "error", err)
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -12,11 +12,13 @@ type UnitOfWork struct {
 	tx          pgx.Tx
 	chatRepo    ChatRepository
 	messageRepo MessageRepository
+	userRepo    UserRepository
+	attachRepo  AttachmentRepository
 	logger      *slog.Logger
 }
 
 func NewUnitOfWork(tx pgx.Tx, logger *slog.Logger) *UnitOfWork {
-	return &UnitOfWork{tx: tx, chatRepo: ChatRepository{tx: tx, logger: logger}, messageRepo: MessageRepository{tx: tx, logger: logger}, logger: logger}
+	return &UnitOfWork{tx: tx, chatRepo: ChatRepository{tx: tx, logger: logger}, messageRepo: MessageRepository{tx: tx, logger: logger}, userRepo: UserRepository{tx: tx, logger: logger}, attachRepo: AttachmentRepository{tx: tx, logger: logger}, logger: logger}
 }
 
 func (u *UnitOfWork) ChatRepository() storage.ChatRepository {
@@ -27,6 +29,14 @@ func (u *UnitOfWork) MessageRepository() storage.MessageRepository {
 	return &u.messageRepo
 }
 
+func (u *UnitOfWork) UserRepository() storage.UserRepository {
+	return &u.userRepo
+}
+
+func (u *UnitOfWork) AttachmentRepository() storage.AttachmentRepository {
+	return &u.attachRepo
+}
+
 func (u *UnitOfWork) Commit() error {
 	return u.tx.Commit(context.Background())
 }
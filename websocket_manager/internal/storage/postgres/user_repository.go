@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"websocket_manager/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type UserRepository struct {
+	tx     pgx.Tx
+	logger *slog.Logger
+}
+
+// GetContactIDs returns the ids of the users sharing at least one chat with the user.
+func (repo *UserRepository) GetContactIDs(id uint64) ([]uint64, error) {
+	rows, err := repo.tx.Query(context.Background(), `SELECT DISTINCT other.user_id FROM chat_users cu
+		JOIN chat_users other ON other.chat_id = cu.chat_id AND other.user_id <> cu.user_id
+		WHERE cu.user_id = $1`, id)
+	if err != nil {
+		repo.logger.Error("failed to get contacts", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]uint64, 0)
+	for rows.Next() {
+		var contactID uint64
+		if err := rows.Scan(&contactID); err != nil {
+			repo.logger.Error("failed to scan contact", "error", err)
+			return nil, err
+		}
+		ids = append(ids, contactID)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read contacts", "error", err)
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (repo *UserRepository) UpdateLastSeen(id uint64, at time.Time) error {
+	tag, err := repo.tx.Exec(context.Background(), "UPDATE users SET last_seen_at = $1 WHERE id = $2", at, id)
+	if err != nil {
+		repo.logger.Error("failed to update last seen", "error", err)
+		return mapError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// GetLastSeen returns the last seen time of the users, users never seen are left out.
+func (repo *UserRepository) GetLastSeen(ids []uint64) (map[uint64]time.Time, error) {
+	rows, err := repo.tx.Query(context.Background(), "SELECT id, last_seen_at FROM users WHERE id = ANY($1) AND last_seen_at IS NOT NULL", ids)
+	if err != nil {
+		repo.logger.Error("failed to get last seen", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastSeen := make(map[uint64]time.Time, len(ids))
+	for rows.Next() {
+		var id uint64
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			repo.logger.Error("failed to scan last seen", "error", err)
+			return nil, err
+		}
+		lastSeen[id] = at
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Error("failed to read last seen", "error", err)
+		return nil, err
+	}
+
+	return lastSeen, nil
+}
@@ -1,6 +1,15 @@
 package storage
 
-import "websocket_manager/internal/model"
+import (
+	"errors"
+	"time"
+	"websocket_manager/internal/model"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
 
 type Storage interface {
 	CreateUnitOfWork() (UnitOfWork, error)
@@ -10,18 +19,31 @@ type Storage interface {
 type UnitOfWork interface {
 	ChatRepository() ChatRepository
 	MessageRepository() MessageRepository
+	UserRepository() UserRepository
+	AttachmentRepository() AttachmentRepository
 	Commit() error
 	Rollback() error
 }
 
 type ChatRepository interface {
 	CreateChat(chat *model.Chat) error
+	// OpenDirectChat returns the direct chat of the two users, creating it on first use
+	// with both of them as members. userID is a member again if they left it, peerID
+	// isn't. It reports whether the chat is new.
+	OpenDirectChat(userID uint64, peerID uint64) (*model.Chat, bool, error)
+	GetChatType(id uint64) (model.ChatType, error)
 	UpdateChat(chat *model.Chat) error
 	DeleteChat(id uint64) error
 	AddUserToChat(chatUsers *model.ChatUsers) error
 	DeleteUserFromChat(chatUsers *model.ChatUsers) error
 	GetAllUsersIDInChat(id uint64) ([]uint64, error)
-	GetOwnerID(id uint64) (uint64, error)
+	IsMember(chatID uint64, userID uint64) (bool, error)
+	GetRole(chatID uint64, userID uint64) (model.ChatRole, error)
+	SetRole(chatUsers *model.ChatUsers) error
+	TransferOwnership(chatID uint64, fromUserID uint64, toUserID uint64) error
+	GetCursors(userID uint64) ([]model.ChatCursor, error)
+	MarkDelivered(chatID uint64, userID uint64, messageID uint64) error
+	MarkRead(chatID uint64, userID uint64, messageID uint64) error
 	GetAllUserChats(id uint64) ([]model.Chat, error)
 	GetChatInfo(id uint64) (*model.Chat, []model.User, error)
 }
@@ -31,5 +53,41 @@ type MessageRepository interface {
 	UpdateMessage(msg *model.Message) error
 	DeleteMessage(id uint64) error
 	GetAllMessagesInChat(chatID uint64) ([]model.Message, error)
+	GetMessagesPage(chatID uint64, beforeID uint64, afterID uint64, limit int) ([]model.Message, error)
 	GetSenderID(id uint64) (uint64, error)
+	GetChatID(id uint64) (uint64, error)
+	GetMessage(id uint64) (*model.Message, error)
+	GetThread(rootID uint64, afterID uint64, limit int) ([]model.Message, error)
+	// AddReaction reports whether the reaction is new, adding it again changes nothing.
+	AddReaction(id uint64, userID uint64, emoji string) (bool, error)
+	RemoveReaction(id uint64, userID uint64, emoji string) error
+	// GetReactions counts the reactions of the messages, Me is set for userID's own.
+	GetReactions(ids []uint64, userID uint64) (map[uint64][]model.ReactionCount, error)
+	// SearchMessages searches the chats userID is a member of.
+	SearchMessages(userID uint64, search *model.MessageSearch, limit int) ([]model.SearchResult, error)
+	AckMessages(chatID uint64, userID uint64, ids []uint64, status model.ReceiptStatus) ([]uint64, error)
+	GetReceipts(id uint64) ([]model.MessageReceipt, error)
+}
+
+type UserRepository interface {
+	GetContactIDs(id uint64) ([]uint64, error)
+	UpdateLastSeen(id uint64, at time.Time) error
+	GetLastSeen(ids []uint64) (map[uint64]time.Time, error)
+}
+
+type AttachmentRepository interface {
+	CreateAttachment(attachment *model.Attachment) error
+	// AttachToMessage links the uploader's unlinked attachments to the message and
+	// returns the ones it linked.
+	AttachToMessage(ids []uint64, userID uint64, messageID uint64) ([]model.Attachment, error)
+	// GetAttachment returns the attachment and the chat of its message, 0 while unlinked.
+	GetAttachment(id uint64) (*model.Attachment, uint64, error)
+	GetByMessages(messageIDs []uint64) (map[uint64][]model.Attachment, error)
+	// DeleteUnlinked deletes the attachments uploaded before the time and never sent.
+	DeleteUnlinked(before time.Time) (int64, error)
+	// GetDeletedBlobs returns up to limit storage keys of deleted attachments, whatever
+	// deleted them, whose blobs are left to remove. ForgetDeletedBlobs drops the keys
+	// once their blobs are gone.
+	GetDeletedBlobs(limit int) ([]string, error)
+	ForgetDeletedBlobs(keys []string) error
 }